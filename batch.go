@@ -0,0 +1,49 @@
+// +build genx
+
+package cmap
+
+// SetBatch sets all the key/value pairs in kvs, it groups them by shard and locks every shard once,
+// later pairs win if kvs has duplicate keys.
+// If there are watchers, hooks or a changelog, the pairs are set one at a time so every mutation is observed.
+func (cm *CMap) SetBatch(kvs []KV) {
+	if ob := cm.observers(); ob != nil {
+		for i := range kvs {
+			cm.Set(kvs[i].Key, kvs[i].Value)
+		}
+		return
+	}
+
+	var (
+		idx    = make([]int, len(kvs))
+		starts = make([]int, len(cm.shards)+1)
+	)
+
+	for i := range kvs {
+		idx[i] = cm.shardIndex(kvs[i].Key)
+		starts[idx[i]+1]++
+	}
+
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+
+	// counting sort, keeps the original order of the pairs inside every shard.
+	order, pos := make([]int, len(kvs)), append([]int(nil), starts[:len(cm.shards)]...)
+	for i, si := range idx {
+		order[pos[si]] = i
+		pos[si]++
+	}
+
+	for si, lm := range cm.shards {
+		start, end := starts[si], starts[si+1]
+		if start == end {
+			continue
+		}
+
+		lm.lock()
+		for _, i := range order[start:end] {
+			lm.m[kvs[i].Key] = kvs[i].Value
+		}
+		lm.l.Unlock()
+	}
+}
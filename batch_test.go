@@ -0,0 +1,38 @@
+package cmap_test
+
+import (
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestSetBatch(t *testing.T) {
+	cm := cmap.NewSize(8)
+	kvs := make([]cmap.KV, 0, 1001)
+	for i := 0; i < 1000; i++ {
+		kvs = append(kvs, cmap.KV{Key: i, Value: i})
+	}
+	kvs = append(kvs, cmap.KV{Key: 0, Value: "last"})
+
+	cm.SetBatch(kvs)
+	if cm.Len() != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", cm.Len())
+	}
+	if v := cm.Get(0); v != "last" {
+		t.Fatalf("expected the last duplicate to win, got %v", v)
+	}
+	for i := 1; i < 1000; i++ {
+		if v := cm.Get(i); v != i {
+			t.Fatalf("expected %d, got %v", i, v)
+		}
+	}
+
+	var sets int
+	cm.OnSet(func(cmap.Event) { sets++ }, cmap.HookLocked)
+	cm.SetBatch(kvs[:10])
+	if sets != 10 {
+		t.Fatalf("expected 10 hook calls, got %d", sets)
+	}
+
+	cm.SetBatch(nil)
+}
@@ -0,0 +1,120 @@
+// +build genx
+
+package cmap
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler using the snapshot format, see SaveTo.
+func (cm *CMap) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the contents and the number of shards of the map
+// with the snapshot in p.
+// Hot key tracking and lock profiling stay enabled, the hot key stats and lock waits are reset.
+// It doesn't notify watchers, hooks or the changelog, and it isn't safe to call while the map is being used.
+func (cm *CMap) UnmarshalBinary(p []byte) error {
+	ncm, err := LoadFrom(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+
+	var lp *lockProfile
+	if len(cm.shards) > 0 {
+		lp = cm.shards[0].lockProfile()
+	}
+
+	cm.shards, cm.clock, cm.seed = ncm.shards, ncm.clock, ncm.seed
+	if cm.keysPool.New == nil { // zero value CMap, ex. allocated by encoding/gob
+		cm.keysPool.New = ncm.keysPool.New
+	}
+
+	// both are sized for the old number of shards
+	if ht := cm.hotTracker(); ht != nil {
+		cm.hot.Store(newHotTracker(len(cm.shards), ht.cfg))
+	}
+	if lp != nil {
+		cm.EnableLockProfiling(lp.name)
+	}
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder, see MarshalBinary.
+func (cm *CMap) GobEncode() ([]byte, error) { return cm.MarshalBinary() }
+
+// GobDecode implements gob.GobDecoder, see UnmarshalBinary.
+func (cm *CMap) GobDecode(p []byte) error { return cm.UnmarshalBinary(p) }
+
+// MarshalBinary implements encoding.BinaryMarshaler using the snapshot format with a single shard.
+func (lm *LMap) MarshalBinary() ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		k   KT
+		v   VT
+	)
+
+	sw, err := snapshot.NewWriter(&buf, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v), 1, lm.Len())
+	if err != nil {
+		return nil, err
+	}
+	if err = sw.WriteShard(0, lm.snapshot); err != nil {
+		return nil, err
+	}
+	if err = sw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the contents of the map with the snapshot in p.
+// Snapshots of a CMap are accepted too, all their shards are merged.
+func (lm *LMap) UnmarshalBinary(p []byte) error {
+	sr, err := snapshot.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+
+	m := make(map[KT]VT)
+	add := func(k, v interface{}) error {
+		key, val, err := decodeEntry(k, v)
+		if err != nil {
+			return err
+		}
+		m[key] = val
+		return nil
+	}
+
+	for {
+		if _, err = sr.ReadShard(add); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if lm.l == nil { // zero value LMap, ex. allocated by encoding/gob
+		lm.l, lm.clock = new(sync.RWMutex), newClock()
+	}
+
+	lm.lock()
+	lm.m = m
+	lm.l.Unlock()
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder, see MarshalBinary.
+func (lm *LMap) GobEncode() ([]byte, error) { return lm.MarshalBinary() }
+
+// GobDecode implements gob.GobDecoder, see UnmarshalBinary.
+func (lm *LMap) GobDecode(p []byte) error { return lm.UnmarshalBinary(p) }
@@ -0,0 +1,171 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+type gobMsg struct {
+	Name string
+	M    *cmap.CMap
+	L    *cmap.LMap
+}
+
+func TestGob(t *testing.T) {
+	in := gobMsg{Name: "x", M: cmap.NewSize(4), L: cmap.NewLMap()}
+	for i := 0; i < 100; i++ {
+		in.M.Set(i, "v")
+		in.L.Set(i, i*2)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	var out gobMsg
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Name != "x" || out.M.NumShards() != 4 || out.M.Len() != 100 || out.L.Len() != 100 {
+		t.Fatalf("unexpected decoded message: %+v", out)
+	}
+	for i := 0; i < 100; i++ {
+		if out.M.Get(i) != "v" || out.L.Get(i) != i*2 {
+			t.Fatalf("%d: unexpected values %v, %v", i, out.M.Get(i), out.L.Get(i))
+		}
+	}
+	if keys := out.M.Keys(); len(keys) != 100 {
+		t.Fatalf("expected 100 keys, got %d", len(keys))
+	}
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	cm := cmap.NewSize(16)
+	cm.Set("a", 1)
+
+	p, err := cm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ncm := cmap.New()
+	ncm.Set("b", 2)
+	if err = ncm.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+	if ncm.NumShards() != 16 || ncm.Len() != 1 || ncm.Get("a") != 1 {
+		t.Fatalf("unexpected map: %d shards, %d keys", ncm.NumShards(), ncm.Len())
+	}
+
+	var lm cmap.LMap
+	if err = lm.UnmarshalBinary(p); err != nil || lm.Len() != 1 || lm.Get("a") != 1 {
+		t.Fatalf("LMap from a CMap snapshot: %v", err)
+	}
+
+	if err = ncm.UnmarshalBinary(p[:len(p)-3]); err == nil {
+		t.Fatal("expected an error for a truncated snapshot")
+	}
+	if ncm.Len() != 1 {
+		t.Fatal("a failed UnmarshalBinary shouldn't modify the map")
+	}
+}
+
+func TestUnmarshalBinaryMoreShards(t *testing.T) {
+	src := cmap.NewSize(64)
+	for i := 0; i < 1000; i++ {
+		src.Set(i, i)
+	}
+	p, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm := cmap.NewSize(4)
+	cm.EnableHotKeys(&cmap.HotKeysConfig{SampleRate: 1})
+	cm.EnableLockProfiling("grow")
+	if err = cm.UnmarshalBinary(p); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if cm.Get(i) != i {
+			t.Fatalf("%d: unexpected value %v", i, cm.Get(i))
+		}
+	}
+	if hs := cm.HotShards(0); len(hs) != 64 || hs[0].Hits == 0 {
+		t.Fatalf("the hot key tracker wasn't resized: %+v", hs)
+	}
+	if s := cm.LockWaits(); s.Count < 1000 {
+		t.Fatalf("lock profiling should still be enabled, got %d waits", s.Count)
+	}
+}
+
+func TestBinaryMarshalerNil(t *testing.T) {
+	in := gobMsg{M: cmap.NewSize(4), L: cmap.NewLMap()}
+	in.M.Set("a", nil)
+	in.M.Set(nil, 1)
+	in.L.Set("a", nil)
+	in.L.Set(nil, 1)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&in); err != nil {
+		t.Fatal(err)
+	}
+	var out gobMsg
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := out.M.GetOK("a"); !ok || v != nil || out.M.Get(nil) != 1 {
+		t.Fatalf("unexpected CMap: %v", out.M.Keys())
+	}
+	if v, ok := out.L.GetOK("a"); !ok || v != nil || out.L.Get(nil) != 1 {
+		t.Fatalf("unexpected LMap: %v", out.L.Keys(nil))
+	}
+}
+
+func TestUnmarshalBinaryManyShards(t *testing.T) {
+	var buf bytes.Buffer
+	sw, err := snapshot.NewWriter(&buf, snapshot.GobCodec{}, snapshot.GobCodec{}, 1<<13, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1<<13; i++ {
+		err = sw.WriteShard(i, func(add func(k, v interface{}) error) error {
+			if i == 0 {
+				return add("a", 1)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := cmap.NewSize(4)
+	if err = cm.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if cm.NumShards() != 1<<13 || cm.Get("a") != 1 {
+		t.Fatalf("unexpected map: %d shards, %d keys", cm.NumShards(), cm.Len())
+	}
+
+	// and back, MarshalBinary keeps the shard count too.
+	p, err := cm.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ncm := cmap.New()
+	if err = ncm.UnmarshalBinary(p); err != nil || ncm.NumShards() != 1<<13 || ncm.Get("a") != 1 {
+		t.Fatalf("unexpected map: %d shards, %v", ncm.NumShards(), err)
+	}
+}
@@ -0,0 +1,137 @@
+// +build genx
+
+package cmap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoChangelog is returned by ChangesSince if the changelog isn't enabled.
+var ErrNoChangelog = errors.New("cmap: changelog is not enabled")
+
+// ChangesTruncatedError is returned by ChangesSince when some of the requested changes were already
+// overwritten, the consumer needs to resync from a full copy of the map.
+type ChangesTruncatedError struct {
+	Since  uint64
+	Oldest uint64
+}
+
+func (e *ChangesTruncatedError) Error() string {
+	return fmt.Sprintf("cmap: changes since %d were truncated, the oldest available change is %d", e.Since, e.Oldest)
+}
+
+// ChangesAheadError is returned by ChangesSince for a sequence number newer than the last change,
+// ex. one from before the map was recreated.
+type ChangesAheadError struct {
+	Since uint64
+	Last  uint64
+}
+
+func (e *ChangesAheadError) Error() string {
+	return fmt.Sprintf("cmap: changes since %d were requested, the last change is %d", e.Since, e.Last)
+}
+
+// Change is a logged Event with its sequence number.
+type Change struct {
+	Seq uint64
+	Event
+}
+
+// EnableChangelog attaches an in-memory changelog that keeps the last size changes.
+// Calling it again, or after DisableChangelog, replaces the changelog but the sequence numbers continue from the
+// last change, so ChangesSince returns a *ChangesTruncatedError for the changes that weren't kept.
+// Sequence numbers are assigned while the shard is locked, so the order of the changes of a key always matches
+// the order they were applied in.
+// Note that all the mutations go through a single lock while the changelog is enabled.
+func (cm *CMap) EnableChangelog(size int) {
+	if size < 1 {
+		size = 1
+	}
+	cm.updateObservers(func(ob *observers) {
+		start := atomic.LoadUint64(&cm.changeSeq) + 1
+		ob.changelog = &changelog{entries: make([]Change, size), seq: &cm.changeSeq, start: start, next: start}
+	})
+}
+
+// DisableChangelog detaches and releases the changelog.
+func (cm *CMap) DisableChangelog() {
+	cm.updateObservers(func(ob *observers) { ob.changelog = nil })
+}
+
+// LastSeq returns the sequence number of the last change, or 0 if the changelog is empty or isn't enabled.
+func (cm *CMap) LastSeq() uint64 {
+	ob := cm.observers()
+	if ob == nil || ob.changelog == nil {
+		return 0
+	}
+	cl := ob.changelog
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	return cl.next - 1
+}
+
+// ChangesSince returns all the changes with a sequence number higher than seq, ChangesSince(0) returns everything.
+// It returns a *ChangesTruncatedError if some of them are no longer available, including the changes that were
+// logged by a changelog replaced by EnableChangelog, and a *ChangesAheadError if seq is newer than the last change.
+func (cm *CMap) ChangesSince(seq uint64) ([]Change, error) {
+	ob := cm.observers()
+	if ob == nil || ob.changelog == nil {
+		return nil, ErrNoChangelog
+	}
+	return ob.changelog.since(seq)
+}
+
+type changelog struct {
+	mux     sync.Mutex
+	entries []Change
+	seq     *uint64 // the last sequence number, shared by all the changelogs of the map
+	start   uint64  // the first sequence number of this changelog
+	next    uint64
+}
+
+func (cl *changelog) append(ev *Event) {
+	cl.mux.Lock()
+	// a changelog that was just replaced may still take a number, that change is missing from this one.
+	seq := atomic.AddUint64(cl.seq, 1)
+	cl.entries[seq%uint64(len(cl.entries))] = Change{Seq: seq, Event: *ev}
+	cl.next = seq + 1
+	cl.mux.Unlock()
+}
+
+func (cl *changelog) since(seq uint64) ([]Change, error) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	n := uint64(len(cl.entries))
+	oldest := cl.start
+	if cl.next-cl.start > n {
+		oldest = cl.next - n
+	}
+
+	if seq+1 < oldest {
+		return nil, &ChangesTruncatedError{Since: seq, Oldest: oldest}
+	}
+
+	if seq >= cl.next {
+		return nil, &ChangesAheadError{Since: seq, Last: cl.next - 1}
+	}
+
+	out := make([]Change, 0, cl.next-seq-1)
+	for s := seq + 1; s < cl.next; s++ {
+		c := cl.entries[s%n]
+		if c.Seq != s {
+			// the number was taken by the changelog this one replaced, the changes can't be replayed past it.
+			oldest, out = s+1, out[:0]
+			continue
+		}
+		out = append(out, c)
+	}
+
+	if seq+1 < oldest {
+		return nil, &ChangesTruncatedError{Since: seq, Oldest: oldest}
+	}
+	return out, nil
+}
@@ -0,0 +1,151 @@
+package cmap_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestChangelog(t *testing.T) {
+	cm := cmap.New()
+	if _, err := cm.ChangesSince(0); err != cmap.ErrNoChangelog {
+		t.Fatalf("expected ErrNoChangelog, got %v", err)
+	}
+
+	cm.EnableChangelog(4)
+	cm.Set("a", 1)
+	cm.Swap("a", 2)
+	cm.Delete("a")
+
+	chs, err := cm.ChangesSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chs) != 3 || cm.LastSeq() != 3 {
+		t.Fatalf("unexpected changes: %+v", chs)
+	}
+	for i, op := range []cmap.EventOp{cmap.EventSet, cmap.EventSwap, cmap.EventDelete} {
+		if chs[i].Seq != uint64(i+1) || chs[i].Op != op || chs[i].Key != "a" {
+			t.Fatalf("%d: unexpected change: %+v", i, chs[i])
+		}
+	}
+
+	if chs, err = cm.ChangesSince(3); err != nil || len(chs) != 0 {
+		t.Fatalf("expected no changes, got %+v, %v", chs, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		cm.Set(i, i)
+	}
+
+	if chs, err = cm.ChangesSince(9); err != nil || len(chs) != 4 || chs[0].Seq != 10 || chs[3].Seq != 13 {
+		t.Fatalf("unexpected changes: %+v, %v", chs, err)
+	}
+
+	_, err = cm.ChangesSince(2)
+	if te, ok := err.(*cmap.ChangesTruncatedError); !ok || te.Since != 2 || te.Oldest != 10 {
+		t.Fatalf("expected a *ChangesTruncatedError, got %#v", err)
+	}
+
+	cm.DisableChangelog()
+	if _, err := cm.ChangesSince(0); err != cmap.ErrNoChangelog {
+		t.Fatalf("expected ErrNoChangelog, got %v", err)
+	}
+}
+
+func TestChangelogReenable(t *testing.T) {
+	cm := cmap.New()
+	cm.EnableChangelog(8)
+	cm.Set("a", 1)
+	cm.Set("b", 2)
+
+	_, err := cm.ChangesSince(5)
+	if ae, ok := err.(*cmap.ChangesAheadError); !ok || ae.Since != 5 || ae.Last != 2 {
+		t.Fatalf("expected a *ChangesAheadError, got %#v", err)
+	}
+
+	cm.DisableChangelog()
+	cm.Set("c", 3) // not logged
+	cm.EnableChangelog(8)
+	if cm.LastSeq() != 2 {
+		t.Fatalf("expected the sequence to continue from 2, got %d", cm.LastSeq())
+	}
+
+	cm.EnableChangelog(8)
+	cm.Set("d", 4)
+
+	chs, err := cm.ChangesSince(2)
+	if err != nil || len(chs) != 1 || chs[0].Seq != 3 || chs[0].Key != "d" {
+		t.Fatalf("unexpected changes: %+v, %v", chs, err)
+	}
+
+	_, err = cm.ChangesSince(1)
+	if te, ok := err.(*cmap.ChangesTruncatedError); !ok || te.Oldest != 3 {
+		t.Fatalf("expected a *ChangesTruncatedError, got %#v", err)
+	}
+}
+
+func TestChangelogReenableConcurrent(t *testing.T) {
+	cm := cmap.New()
+	cm.EnableChangelog(1 << 12)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+					cm.Set(g*1e6+i%1000, i)
+				}
+			}
+		}(g)
+	}
+
+	for i := 0; i < 100; i++ {
+		seq := cm.LastSeq()
+		cm.EnableChangelog(1 << 12)
+		chs, err := cm.ChangesSince(seq)
+		if _, ok := err.(*cmap.ChangesTruncatedError); ok {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, c := range chs {
+			if c.Seq != seq+uint64(j)+1 {
+				t.Fatalf("expected change %d, got %d", seq+uint64(j)+1, c.Seq)
+			}
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkChangelog(b *testing.B) {
+	for _, enabled := range []bool{false, true} {
+		name := "off"
+		if enabled {
+			name = "on"
+		}
+		b.Run(name, func(b *testing.B) {
+			cm := cmap.New()
+			if enabled {
+				cm.EnableChangelog(1 << 16)
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					cm.Set(i, i)
+					i++
+				}
+			})
+		})
+	}
+}
@@ -5,6 +5,10 @@ package cmap
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+
+	"github.com/OneOfOne/cmap/hashers"
+	"github.com/OneOfOne/cmap/stats"
 )
 
 type (
@@ -17,8 +21,15 @@ const DefaultShardCount = 1 << 8
 
 // CMap is a concurrent safe sharded map to scale on multiple cores.
 type CMap struct {
-	shards   []*LMap
-	keysPool sync.Pool
+	changeSeq uint64 // the last changelog sequence number, first for 64-bit atomic alignment, see changelog.go
+	shards    []*LMap
+	clock     *uint64 // the current generation, see Checkpoint
+	seed      uint64
+	keysPool  sync.Pool
+	hot       atomic.Value // *hotTracker
+	obs       atomic.Value // *observers
+	obsMux    sync.Mutex
+	obsCfg    observers // guarded by obsMux
 }
 
 // New is an alias for NewSize(DefaultShardCount)
@@ -27,7 +38,14 @@ func New() *CMap { return NewSize(DefaultShardCount) }
 // NewSize returns a CMap with the specific shardSize, note that for performance reasons,
 // shardCount must be a power of 2.
 // Higher shardCount will improve concurrency but will consume more memory.
-func NewSize(shardCount int) *CMap {
+// Keys are hashed with a random seed, so keys chosen by an attacker can't be forced into the same shard.
+func NewSize(shardCount int) *CMap { return NewSizeSeed(shardCount, hashers.NewSeed()) }
+
+// NewSizeSeed is like NewSize but uses a fixed hash seed, ex. to get the same shard distribution in tests.
+// The interface{} keys of cmap.CMap are hashed with hashers.MapHash64, which also mixes in a random per-process
+// seed, so there the same seed only gives the same distribution within a process, and ApplyIncremental returns
+// ErrHashMismatch for a snapshot saved by another process.
+func NewSizeSeed(shardCount int, seed uint64) *CMap {
 	// must be a power of 2
 	if shardCount < 1 {
 		shardCount = DefaultShardCount
@@ -35,8 +53,20 @@ func NewSize(shardCount int) *CMap {
 		panic("shardCount must be a power of 2")
 	}
 
+	shards := make([]*LMap, shardCount)
+	for i := range shards {
+		shards[i] = NewLMapSize(shardCount)
+	}
+
+	return newCMap(shards, seed)
+}
+
+// newCMap returns a CMap made of the shards, their number must be a power of 2.
+func newCMap(shards []*LMap, seed uint64) *CMap {
 	cm := &CMap{
-		shards: make([]*LMap, shardCount),
+		shards: shards,
+		clock:  newClock(),
+		seed:   seed,
 	}
 
 	cm.keysPool.New = func() interface{} {
@@ -45,8 +75,8 @@ func NewSize(shardCount int) *CMap {
 		return &out // return a ptr to avoid extra allocation on Get/Put
 	}
 
-	for i := range cm.shards {
-		cm.shards[i] = NewLMapSize(shardCount)
+	for _, lm := range cm.shards {
+		lm.clock = cm.clock
 	}
 
 	return cm
@@ -54,64 +84,108 @@ func NewSize(shardCount int) *CMap {
 
 // ShardForKey returns the LMap that may hold the specific key.
 func (cm *CMap) ShardForKey(key KT) *LMap {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)]
+	return cm.shards[cm.Hash(key)&uint64(len(cm.shards)-1)]
+}
+
+// Hash returns the seeded 64-bit hash the map uses for the key, the key's shard is `Hash(key) & (NumShards()-1)`.
+// For cmap.CMap it is only stable within a process, see NewSizeSeed.
+func (cm *CMap) Hash(key KT) uint64 {
+	return hasher(key, cm.seed)
+}
+
+// shardFor returns the LMap that may hold the specific key and records the access if hot keys are tracked.
+func (cm *CMap) shardFor(key KT) *LMap {
+	return cm.shards[cm.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard that may hold the specific key and records the access if hot keys are tracked.
+func (cm *CMap) shardIndex(key KT) int {
+	h := hasher(key, cm.seed)
+	idx := int(h & uint64(len(cm.shards)-1))
+	if ht := cm.hotTracker(); ht != nil {
+		ht.record(idx, h, key)
+	}
+	return idx
 }
 
 // Set is the equivalent of `map[key] = val`.
 func (cm *CMap) Set(key KT, val VT) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Set(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		cm.mutate(lm, ob, EventSet, key, val, nil)
+		return
+	}
+	lm.Set(key, val)
 }
 
 // SetIfNotExists will only assign val to key if it wasn't already set.
 // Use `Update` if you need more logic.
 func (cm *CMap) SetIfNotExists(key KT, val VT) (set bool) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].SetIfNotExists(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		_, existed := cm.mutate(lm, ob, eventSetIfNotExists, key, val, nil)
+		return !existed
+	}
+	return lm.SetIfNotExists(key, val)
 }
 
 // Get is the equivalent of `val := map[key]`.
 func (cm *CMap) Get(key KT) (val VT) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Get(key)
+	return cm.shardFor(key).Get(key)
 }
 
 // GetOK is the equivalent of `val, ok := map[key]`.
 func (cm *CMap) GetOK(key KT) (val VT, ok bool) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].GetOK(key)
+	return cm.shardFor(key).GetOK(key)
 }
 
 // Has is the equivalent of `_, ok := map[key]`.
 func (cm *CMap) Has(key KT) bool {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Has(key)
+	return cm.shardFor(key).Has(key)
 }
 
 // Delete is the equivalent of `delete(map, key)`.
 func (cm *CMap) Delete(key KT) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Delete(key)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero VT
+		cm.mutate(lm, ob, EventDelete, key, zero, nil)
+		return
+	}
+	lm.Delete(key)
 }
 
 // DeleteAndGet is the equivalent of `oldVal := map[key]; delete(map, key)`.
 func (cm *CMap) DeleteAndGet(key KT) VT {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].DeleteAndGet(key)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero VT
+		old, _ := cm.mutate(lm, ob, EventDelete, key, zero, nil)
+		return old
+	}
+	return lm.DeleteAndGet(key)
 }
 
 // Update calls `fn` with the key's old value (or nil) and assign the returned value to the key.
 // The shard containing the key will be locked, it is NOT safe to call other cmap funcs inside `fn`.
 func (cm *CMap) Update(key KT, fn func(oldval VT) (newval VT)) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Update(key, fn)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero VT
+		cm.mutate(lm, ob, EventUpdate, key, zero, fn)
+		return
+	}
+	lm.Update(key, fn)
 }
 
 // Swap is the equivalent of `oldVal, map[key] = map[key], newVal`.
 func (cm *CMap) Swap(key KT, val VT) VT {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Swap(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		old, _ := cm.mutate(lm, ob, EventSwap, key, val, nil)
+		return old
+	}
+	return lm.Swap(key, val)
 }
 
 // Keys returns a slice of all the keys of the map.
@@ -164,17 +238,31 @@ func (cm *CMap) Len() int {
 
 // ShardDistribution returns the distribution of data amoung all shards.
 // Useful for debugging the efficiency of a hash.
+// All the values are 0 if the map is empty.
 func (cm *CMap) ShardDistribution() []float64 {
 	var (
 		out = make([]float64, len(cm.shards))
 		ln  = float64(cm.Len())
 	)
+	if ln == 0 {
+		return out
+	}
 	for i := range out {
 		out[i] = float64(cm.shards[i].Len()) / ln
 	}
 	return out
 }
 
+// DistributionReport returns detailed statistics about the distribution of data among all shards.
+// Each shard is read-locked separately, so the report isn't an atomic snapshot of a map that is being modified.
+func (cm *CMap) DistributionReport() *stats.DistributionReport {
+	counts := make([]int, len(cm.shards))
+	for i, lm := range cm.shards {
+		counts[i] = lm.Len()
+	}
+	return stats.NewDistributionReport(counts)
+}
+
 // KV holds the key/value returned when Iter is called.
 type KV struct {
 	Key   KT
@@ -223,5 +311,11 @@ func (cm *CMap) iterContext(ctx context.Context, ch chan<- *KV, locked bool) {
 	}
 }
 
+// Seed returns the hash seed of the map, for cmap.CMap it doesn't fix the hashes across processes, see NewSizeSeed.
+func (cm *CMap) Seed() uint64 { return cm.seed }
+
 // NumShards returns the number of shards in the map.
 func (cm *CMap) NumShards() int { return len(cm.shards) }
+
+// Shard returns the i-th shard of the map, it panics if i is out of range.
+func (cm *CMap) Shard(i int) *LMap { return cm.shards[i] }
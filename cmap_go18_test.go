@@ -24,7 +24,20 @@ func TestDistruption(t *testing.T) {
 	}
 
 	t.Logf("%+v", cm.ShardDistribution())
+	t.Logf("%v", cm.DistributionReport())
+}
+
+func TestEmptyDistribution(t *testing.T) {
+	cm := cmap.NewSize(32)
+	for _, v := range cm.ShardDistribution() {
+		if v != 0 {
+			t.Fatalf("expected 0, got %v", v)
+		}
+	}
 
+	if r := cm.DistributionReport(); r.Shards != 32 || r.EmptyShards != 32 || r.Entries != 0 {
+		t.Fatalf("unexpected report: %v", r)
+	}
 }
 
 func TestIter(t *testing.T) {
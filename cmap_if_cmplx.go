@@ -5,4 +5,4 @@ package cmap
 
 import "github.com/OneOfOne/cmap/hashers"
 
-func hasher(key KT) uint32 { return hashers.Mix64to32(uint64(real(key) + imag(key))) }
+func hasher(key KT, seed uint64) uint64 { return hashers.ComplexHash64(complex128(key), seed) }
@@ -15,4 +15,4 @@ package cmap
 
 import "github.com/OneOfOne/cmap/hashers"
 
-func hasher(key KT) uint32 { return hashers.TypeHasher32(key) }
+func hasher(key KT, seed uint64) uint64 { return hashers.MapHash64(key, seed) }
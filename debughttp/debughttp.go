@@ -0,0 +1,354 @@
+// Package debughttp exposes a read-only view of a live CMap over HTTP.
+//
+// Usage:
+//
+//	http.Handle("/debug/cmap/", debughttp.Handler(cm))
+//
+// The handler serves:
+//
+//	/debug/cmap/           an overview of the map: length, shard sizes, distribution and stats when enabled.
+//	/debug/cmap/keys       a paginated list of keys, supports ?limit=, ?cursor= and ?prefix= (stringcmap only).
+//	/debug/cmap/key?k=key  a single key, encoded by the MarshalJSON of its map, for cmap.CMap ?type= sets the type
+//	                       of the key: string (the default), bool, int, int8 to int64, uint, uint8 to uint64,
+//	                       float32 or float64.
+//
+// Only one shard is read-locked at a time, so it is safe to use against production maps.
+package debughttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/stats"
+	"github.com/OneOfOne/cmap/stringcmap"
+	"github.com/OneOfOne/cmap/u64cmap"
+)
+
+const (
+	// DefaultLimit is the default number of keys returned by /keys.
+	DefaultLimit = 100
+	// MaxLimit is the maximum number of keys returned by /keys.
+	MaxLimit = 10000
+
+	numHot = 20
+)
+
+// Map is the interface implemented by all the CMap variants.
+type Map interface {
+	Len() int
+	NumShards() int
+	ShardDistribution() []float64
+	DistributionReport() *stats.DistributionReport
+	LockWaits() stats.DurationSnapshot
+}
+
+// Handler returns an http.Handler that inspects m.
+// Listing and fetching keys is only supported for cmap.CMap, stringcmap.CMap and u64cmap.CMap.
+func Handler(m Map) http.Handler {
+	h := &handler{m: m}
+	switch m := m.(type) {
+	case *stringcmap.CMap:
+		h.keys = func(i int, prefix string) []string {
+			keys := m.Shard(i).Keys(nil)
+			if prefix == "" {
+				return keys
+			}
+			out := keys[:0]
+			for _, k := range keys {
+				if strings.HasPrefix(k, prefix) {
+					out = append(out, k)
+				}
+			}
+			return out
+		}
+		h.get = func(k, _ string) (json.Marshaler, bool, error) {
+			v, ok := m.GetOK(k)
+			one := stringcmap.NewSize(1)
+			one.Set(k, v)
+			return one, ok, nil
+		}
+		h.hot = func() (interface{}, interface{}, bool) {
+			hk := m.HotKeys(numHot)
+			return hk, m.HotShards(numHot), hk != nil
+		}
+
+	case *u64cmap.CMap:
+		h.keys = func(i int, _ string) []string {
+			keys := m.Shard(i).Keys(nil)
+			out := make([]string, len(keys))
+			for i, k := range keys {
+				out[i] = strconv.FormatUint(k, 10)
+			}
+			return out
+		}
+		h.get = func(k, _ string) (json.Marshaler, bool, error) {
+			u, err := strconv.ParseUint(k, 10, 64)
+			if err != nil {
+				return nil, false, err
+			}
+			v, ok := m.GetOK(u)
+			one := u64cmap.NewSize(1)
+			one.Set(u, v)
+			return one, ok, nil
+		}
+		h.hot = func() (interface{}, interface{}, bool) {
+			hk := m.HotKeys(numHot)
+			return hk, m.HotShards(numHot), hk != nil
+		}
+
+	case *cmap.CMap:
+		h.keys = func(i int, _ string) []string {
+			keys := m.Shard(i).Keys(nil)
+			out := make([]string, len(keys))
+			for i, k := range keys {
+				out[i] = fmt.Sprint(k)
+			}
+			return out
+		}
+		h.get = func(k, typ string) (json.Marshaler, bool, error) {
+			key, err := parseKey(k, typ)
+			if err != nil {
+				return nil, false, err
+			}
+			v, ok := m.GetOK(key)
+			one := cmap.NewSize(1)
+			one.Set(key, v)
+			// keys are shown the same way /keys lists them, so bool and float keys can be encoded too.
+			return &cmap.MapWithJSON{CMap: one, MarshalKeyFn: func(key interface{}) (string, error) {
+				return fmt.Sprint(key), nil
+			}}, ok, nil
+		}
+		h.hot = func() (interface{}, interface{}, bool) {
+			hk := m.HotKeys(numHot)
+			return hk, m.HotShards(numHot), hk != nil
+		}
+	}
+
+	return h
+}
+
+type handler struct {
+	m    Map
+	keys func(shard int, prefix string) []string
+	get  func(key, typ string) (kv json.Marshaler, found bool, err error)
+	hot  func() (keys, shards interface{}, enabled bool)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch p := strings.TrimSuffix(r.URL.Path, "/"); {
+	case strings.HasSuffix(p, "/keys"):
+		h.serveKeys(w, r)
+	case strings.HasSuffix(p, "/key"):
+		h.serveKey(w, r)
+	default:
+		h.serveOverview(w, r)
+	}
+}
+
+type overview struct {
+	Len          int                       `json:"len"`
+	Shards       int                       `json:"shards"`
+	Distribution []float64                 `json:"distribution"`
+	Report       *stats.DistributionReport `json:"report"`
+	HotKeys      interface{}               `json:"hotKeys,omitempty"`
+	HotShards    interface{}               `json:"hotShards,omitempty"`
+	LockWaits    *lockWaits                `json:"lockWaits,omitempty"`
+}
+
+type lockWaits struct {
+	Count uint64 `json:"count"`
+	Mean  string `json:"mean"`
+	P50   string `json:"p50"`
+	P99   string `json:"p99"`
+	Max   string `json:"max"`
+}
+
+func (h *handler) serveOverview(w http.ResponseWriter, r *http.Request) {
+	ov := overview{
+		Len:          h.m.Len(),
+		Shards:       h.m.NumShards(),
+		Distribution: h.m.ShardDistribution(),
+		Report:       h.m.DistributionReport(),
+	}
+
+	if h.hot != nil {
+		if keys, shards, ok := h.hot(); ok {
+			ov.HotKeys, ov.HotShards = keys, shards
+		}
+	}
+
+	if lw := h.m.LockWaits(); lw.Count > 0 {
+		ov.LockWaits = &lockWaits{
+			Count: lw.Count,
+			Mean:  lw.Mean().String(),
+			P50:   lw.Quantile(0.5).String(),
+			P99:   lw.Quantile(0.99).String(),
+			Max:   lw.Quantile(1).String(),
+		}
+	}
+
+	writeJSON(w, &ov)
+}
+
+type keysPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// serveKeys walks the shards in order, the cursor is "shard:offset" into the sorted keys of the shard.
+func (h *handler) serveKeys(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		http.Error(w, fmt.Sprintf("listing keys isn't supported for %T", h.m), http.StatusNotImplemented)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := DefaultLimit
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			http.Error(w, "invalid limit: "+l, http.StatusBadRequest)
+			return
+		}
+		if limit > MaxLimit {
+			limit = MaxLimit
+		}
+	}
+
+	shard, offset, err := parseCursor(q.Get("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := keysPage{Keys: []string{}}
+	for ; shard < h.m.NumShards(); shard, offset = shard+1, 0 {
+		keys := h.keys(shard, q.Get("prefix"))
+		sort.Strings(keys)
+		if offset > len(keys) {
+			offset = len(keys)
+		}
+		keys = keys[offset:]
+
+		if n := limit - len(page.Keys); len(keys) > n {
+			page.Keys = append(page.Keys, keys[:n]...)
+			page.Next = strconv.Itoa(shard) + ":" + strconv.Itoa(offset+n)
+			break
+		}
+		page.Keys = append(page.Keys, keys...)
+	}
+
+	writeJSON(w, &page)
+}
+
+func (h *handler) serveKey(w http.ResponseWriter, r *http.Request) {
+	if h.get == nil {
+		http.Error(w, fmt.Sprintf("fetching keys isn't supported for %T", h.m), http.StatusNotImplemented)
+		return
+	}
+
+	q := r.URL.Query()
+	k := q.Get("k")
+	kv, found, err := h.get(k, q.Get("type"))
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case !found:
+		http.Error(w, "key not found: "+k, http.StatusNotFound)
+	default:
+		writeJSON(w, kv)
+	}
+}
+
+// parseKey converts k to the type named by typ, see the package docs.
+func parseKey(k, typ string) (v interface{}, err error) {
+	var (
+		i int64
+		u uint64
+		f float64
+	)
+
+	switch typ {
+	case "", "string":
+		return k, nil
+	case "bool":
+		v, err = strconv.ParseBool(k)
+	case "int":
+		v, err = strconv.Atoi(k)
+	case "int8":
+		i, err = strconv.ParseInt(k, 10, 8)
+		v = int8(i)
+	case "int16":
+		i, err = strconv.ParseInt(k, 10, 16)
+		v = int16(i)
+	case "int32":
+		i, err = strconv.ParseInt(k, 10, 32)
+		v = int32(i)
+	case "int64":
+		v, err = strconv.ParseInt(k, 10, 64)
+	case "uint":
+		u, err = strconv.ParseUint(k, 10, strconv.IntSize)
+		v = uint(u)
+	case "uint8":
+		u, err = strconv.ParseUint(k, 10, 8)
+		v = uint8(u)
+	case "uint16":
+		u, err = strconv.ParseUint(k, 10, 16)
+		v = uint16(u)
+	case "uint32":
+		u, err = strconv.ParseUint(k, 10, 32)
+		v = uint32(u)
+	case "uint64":
+		v, err = strconv.ParseUint(k, 10, 64)
+	case "float32":
+		f, err = strconv.ParseFloat(k, 32)
+		v = float32(f)
+	case "float64":
+		v, err = strconv.ParseFloat(k, 64)
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", typ)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key %q: %v", typ, k, err)
+	}
+	return v, nil
+}
+
+func parseCursor(c string) (shard, offset int, err error) {
+	if c == "" {
+		return
+	}
+	parts := strings.Split(c, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid cursor: %s", c)
+	}
+	if shard, err = strconv.Atoi(parts[0]); err != nil || shard < 0 {
+		return 0, 0, fmt.Errorf("invalid cursor: %s", c)
+	}
+	if offset, err = strconv.Atoi(parts[1]); err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("invalid cursor: %s", c)
+	}
+	return
+}
+
+// writeJSON encodes v before writing anything, so encoding errors can still be reported with a 500.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the status was already sent, there's nothing left to report a write error to.
+	buf.WriteTo(w)
+}
@@ -0,0 +1,170 @@
+package debughttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/stringcmap"
+	"github.com/OneOfOne/cmap/u64cmap"
+)
+
+func get(t *testing.T, h http.Handler, url string, code int, out interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != code {
+		t.Fatalf("%s: expected %d, got %d: %s", url, code, rec.Code, rec.Body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	cm := stringcmap.NewSize(8)
+	for i := 0; i < 250; i++ {
+		cm.Set("a:"+strconv.Itoa(i), i)
+		cm.Set("b:"+strconv.Itoa(i), i)
+	}
+	cm.EnableHotKeys(nil)
+	h := Handler(cm)
+
+	var ov struct {
+		Len       int
+		Shards    int
+		HotShards []interface{}
+	}
+	get(t, h, "/debug/cmap/", 200, &ov)
+	if ov.Len != 500 || ov.Shards != 8 || len(ov.HotShards) == 0 {
+		t.Fatalf("unexpected overview: %+v", ov)
+	}
+
+	seen := map[string]bool{}
+	url := "/debug/cmap/keys?prefix=a:&limit=30"
+	for {
+		var page keysPage
+		get(t, h, url, 200, &page)
+		for _, k := range page.Keys {
+			if seen[k] || k[:2] != "a:" {
+				t.Fatalf("unexpected key %q", k)
+			}
+			seen[k] = true
+		}
+		if page.Next == "" {
+			break
+		}
+		url = "/debug/cmap/keys?prefix=a:&limit=30&cursor=" + page.Next
+	}
+	if len(seen) != 250 {
+		t.Fatalf("expected 250 keys, got %d", len(seen))
+	}
+
+	var kv map[string]int
+	get(t, h, "/debug/cmap/key?k=b:42", 200, &kv)
+	if kv["b:42"] != 42 {
+		t.Fatalf("unexpected value: %v", kv)
+	}
+
+	get(t, h, "/debug/cmap/key?k=c", 404, nil)
+	get(t, h, "/debug/cmap/keys?cursor=x", 400, nil)
+}
+
+func TestHandlerU64(t *testing.T) {
+	cm := u64cmap.New()
+	cm.Set(42, "answer")
+	h := Handler(cm)
+
+	var page keysPage
+	get(t, h, "/debug/cmap/keys", 200, &page)
+	if len(page.Keys) != 1 || page.Keys[0] != "42" {
+		t.Fatalf("unexpected keys: %+v", page)
+	}
+
+	var kv map[string]string
+	get(t, h, "/debug/cmap/key?k=42", 200, &kv)
+	if kv["42"] != "answer" {
+		t.Fatalf("unexpected value: %v", kv)
+	}
+	get(t, h, "/debug/cmap/key?k=x", 400, nil)
+}
+
+func TestHandlerKeyTypes(t *testing.T) {
+	cm := cmap.New()
+	cm.Set(42, "int")
+	cm.Set(int64(42), "int64")
+	cm.Set(uint8(42), "uint8")
+	cm.Set(4.5, "float64")
+	cm.Set(true, "bool")
+	cm.Set("42", "string")
+	h := Handler(cm)
+
+	for url, want := range map[string]string{
+		"/debug/cmap/key?k=42":               "string",
+		"/debug/cmap/key?k=42&type=string":   "string",
+		"/debug/cmap/key?k=42&type=int":      "int",
+		"/debug/cmap/key?k=42&type=int64":    "int64",
+		"/debug/cmap/key?k=42&type=uint8":    "uint8",
+		"/debug/cmap/key?k=4.5&type=float64": "float64",
+		"/debug/cmap/key?k=true&type=bool":   "bool",
+	} {
+		var kv map[string]string
+		get(t, h, url, 200, &kv)
+		if len(kv) != 1 {
+			t.Fatalf("%s: expected one value, got %v", url, kv)
+		}
+		for _, v := range kv {
+			if v != want {
+				t.Fatalf("%s: expected %s, got %v", url, want, kv)
+			}
+		}
+	}
+
+	get(t, h, "/debug/cmap/key?k=42&type=int32", 404, nil)
+	get(t, h, "/debug/cmap/key?k=300&type=uint8", 400, nil)
+	get(t, h, "/debug/cmap/key?k=42&type=complex", 400, nil)
+}
+
+type failingValue struct{}
+
+func (failingValue) MarshalJSON() ([]byte, error) { return nil, errors.New("nope") }
+
+func TestHandlerEncodeError(t *testing.T) {
+	cm := stringcmap.New()
+	cm.Set("bad", failingValue{})
+
+	rec := httptest.NewRecorder()
+	Handler(cm).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/cmap/key?k=bad", nil))
+	if rec.Code != 500 || rec.Header().Get("Content-Type") == "application/json" {
+		t.Fatalf("expected a plain 500, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandlerKeyMarshalJSON(t *testing.T) {
+	cm := stringcmap.New()
+	cm.Set("<k>", []int{1, 2})
+
+	one := stringcmap.NewSize(1)
+	one.Set("<k>", []int{1, 2})
+	want, err := one.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw json.RawMessage
+	get(t, Handler(cm), "/debug/cmap/key?k=%3Ck%3E", 200, &raw)
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Fatalf("expected %s, got %s", want, buf.Bytes())
+	}
+}
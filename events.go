@@ -0,0 +1,123 @@
+// +build genx
+
+package cmap
+
+// EventOp is the kind of mutation described by an Event.
+type EventOp uint8
+
+// The mutations reported to watchers, hooks and the changelog.
+const (
+	EventSet EventOp = iota + 1
+	EventDelete
+	EventUpdate
+	EventSwap
+	EventEvict
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventUpdate:
+		return "update"
+	case EventSwap:
+		return "swap"
+	case EventEvict:
+		return "evict"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a single mutation of a key.
+// Existed reports whether the key was in the map before the mutation, OldValue is only valid if it is true.
+type Event struct {
+	Op       EventOp
+	Key      KT
+	OldValue VT
+	NewValue VT
+	Existed  bool
+}
+
+// eventSetIfNotExists is only used internally by mutate, it is reported as EventSet.
+const eventSetIfNotExists EventOp = 0
+
+// observers holds everything that needs to be notified of mutations, it is replaced, never modified, when
+// something is added or removed.
+type observers struct {
+	watchers  []*watcher
+	changelog *changelog
+	hooks     []*hook
+	onPanic   func(ev Event, v interface{})
+}
+
+func (cm *CMap) observers() *observers {
+	ob, _ := cm.obs.Load().(*observers)
+	return ob
+}
+
+// updateObservers replaces the current observers with a modified copy, fn must not keep a reference to ob.
+// The observers are only published if they have something to notify, so the mutating funcs can use the fast path.
+func (cm *CMap) updateObservers(fn func(ob *observers)) {
+	cm.obsMux.Lock()
+	ob := cm.obsCfg
+	fn(&ob)
+	cm.obsCfg = ob
+	if len(ob.watchers) == 0 && ob.changelog == nil && len(ob.hooks) == 0 {
+		cm.obs.Store((*observers)(nil))
+	} else {
+		cm.obs.Store(&ob)
+	}
+	cm.obsMux.Unlock()
+}
+
+// mutate is the slow path of all the mutating funcs when there are observers attached to the map, ob may be nil.
+// Changes are logged and HookLocked hooks run while the shard is locked,
+// watchers and HookAfterUnlock hooks are notified after it is unlocked.
+func (cm *CMap) mutate(lm *LMap, ob *observers, op EventOp, key KT, val VT, fn func(VT) VT) (old VT, existed bool) {
+	lm.lock()
+	old, existed = lm.m[key]
+
+	switch op {
+	case eventSetIfNotExists:
+		if existed {
+			lm.l.Unlock()
+			return
+		}
+		op = EventSet
+		lm.m[key] = val
+	case EventSet, EventSwap:
+		lm.m[key] = val
+	case EventUpdate:
+		val = fn(old)
+		lm.m[key] = val
+	case EventDelete, EventEvict:
+		if !existed {
+			lm.l.Unlock()
+			return
+		}
+		delete(lm.m, key)
+	}
+
+	if ob == nil {
+		lm.l.Unlock()
+		return
+	}
+
+	ev := Event{Op: op, Key: key, OldValue: old, NewValue: val, Existed: existed}
+	if ob.changelog != nil {
+		ob.changelog.append(&ev)
+	}
+	ob.runHooks(HookLocked, &ev)
+
+	lm.l.Unlock()
+
+	ob.runHooks(HookAfterUnlock, &ev)
+	for _, w := range ob.watchers {
+		w.notify(&ev)
+	}
+
+	return
+}
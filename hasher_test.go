@@ -0,0 +1,93 @@
+package cmap_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/hashers"
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+type constHasher int
+
+func (constHasher) Hash() uint64 { return 42 }
+
+func TestHasherFullWidth(t *testing.T) {
+	cm := cmap.NewSize(64)
+	for i := int64(0); i < 640; i++ {
+		cm.Set(i<<32, i) // only the high bits differ
+		cm.Set(uint64(i)<<40, i)
+		cm.Set(float64(i)*1e12, i)
+	}
+
+	empty := 0
+	for _, d := range cm.ShardDistribution() {
+		if d == 0 {
+			empty++
+		}
+	}
+	if empty > 0 {
+		t.Fatalf("%d of 64 shards are empty", empty)
+	}
+
+	for i := 0; i < 10; i++ {
+		cm.Set(constHasher(i), i)
+	}
+	if cm.ShardForKey(constHasher(0)) != cm.ShardForKey(constHasher(9)) || cm.Get(constHasher(3)) != 3 {
+		t.Fatal("KeyHasher should override the default hasher")
+	}
+}
+
+func TestApplyIncrementalHashMismatch(t *testing.T) {
+	cm := cmap.NewSizeSeed(4, 1)
+	cm.Set("a", 1)
+
+	var buf bytes.Buffer
+	sw, err := snapshot.NewWriterSeed(&buf, snapshot.GobCodec{}, snapshot.GobCodec{}, 4, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := 0
+	for cm.ShardForKey("a") == cm.Shard(wrong) {
+		wrong++
+	}
+	if err = sw.WriteShard(wrong, func(add func(k, v interface{}) error) error { return add("a", 2) }); err != nil {
+		t.Fatal(err)
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cm.ApplyIncremental(&buf); err != cmap.ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if cm.Get("a") != 1 {
+		t.Fatal("the shard shouldn't have been replaced")
+	}
+}
+
+func TestHash64(t *testing.T) {
+	cm := cmap.NewSizeSeed(32, 7)
+	seen := map[uint64]bool{}
+	for i := int64(0); i < 1000; i++ {
+		k := i << 40
+		h := cm.Hash(k)
+		if seen[h] {
+			t.Fatalf("%d: duplicate hash %#x", k, h)
+		}
+		seen[h] = true
+
+		if cm.ShardForKey(k) != cm.Shard(int(h&uint64(cm.NumShards()-1))) {
+			t.Fatalf("%d: ShardForKey doesn't match Hash", k)
+		}
+	}
+
+	if cm.Hash("a") != cmap.NewSizeSeed(32, 7).Hash("a") || cm.Hash("a") == cmap.NewSizeSeed(32, 8).Hash("a") {
+		t.Fatal("Hash should only depend on the seed")
+	}
+	if cm.Hash(int64(1)<<40) != hashers.MapHash64(int64(1)<<40, 7) {
+		t.Fatal("the default hasher should be hashers.MapHash64")
+	}
+}
@@ -1,10 +1,6 @@
 package hashers
 
-import (
-	"fmt"
-	"math"
-	"reflect"
-)
+import "fmt"
 
 // KeyHasher is a type that provides its own hash function.
 type KeyHasher interface {
@@ -14,82 +10,98 @@ type KeyHasher interface {
 // TypeHasher32 returns a hash for the specific key for internal sharding.
 // By default, those types are supported as keys: KeyHasher, string, uint64, int64, uint32, int32, uint16, int16, uint8,
 // int8, uint, int, float64, float32 and fmt.Stringer.
-// Falls back to Fnv32(reflect.ValueOf(v).String()).
+// Other types, like structs, arrays, pointers, bools and complex numbers, are hashed with ReflectHash64.
 func TypeHasher32(v interface{}) uint32 {
+	return TypeHasher32Seed(v, 0)
+}
+
+// TypeHasher32Seed is TypeHasher32 with a seed, see NewSeed.
+func TypeHasher32Seed(v interface{}, seed uint64) uint32 {
+	s32 := uint32(seed)
 	switch v := v.(type) {
 	case KeyHasher:
-		return Mix32(uint32(v.Hash()))
+		return Mix32(uint32(v.Hash()) ^ s32)
 	case string:
-		return Fnv32(v)
+		return WyHash32Seed(v, seed)
 	case int:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case uint:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case uint64:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case int64:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case uint32:
-		return Mix32(v)
+		return Mix32(v ^ s32)
 	case int32:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case uint16:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case int16:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case uint8:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case int8:
-		return Mix32(uint32(v))
+		return Mix32(uint32(v) ^ s32)
 	case float64:
-		return Mix32(uint32(math.Float64bits(v)))
+		b := floatBits(v) // round floats only differ in the high bits
+		return Mix32(uint32(b^b>>32) ^ s32)
 	case float32:
-		return Mix32(math.Float32bits(v))
+		b := floatBits(float64(v))
+		return Mix32(uint32(b^b>>32) ^ s32)
 	case fmt.Stringer:
-		return Fnv32(v.String())
+		return WyHash32Seed(v.String(), seed)
 	default:
-		return Fnv32(reflect.ValueOf(v).String())
+		h := ReflectHash64(v, seed)
+		return uint32(h ^ h>>32)
 	}
 }
 
 // TypeHasher64 returns a hash for the specific key for internal sharding.
 // By default, those types are supported as keys: KeyHasher, string, uint64, int64, uint32, int32, uint16, int16, uint8,
 // int8, uint, int, float64, float32 and fmt.Stringer.
-// Falls back to Fnv64(reflect.ValueOf(v).String()).
+// Other types, like structs, arrays, pointers, bools and complex numbers, are hashed with ReflectHash64.
 func TypeHasher64(v interface{}) uint64 {
+	return TypeHasher64Seed(v, 0)
+}
+
+// TypeHasher64Seed is TypeHasher64 with a seed, see NewSeed.
+// Unlike MapHash64, the hash only depends on the key and the seed, so it is the same in every process
+// as long as the keys don't contain pointers.
+func TypeHasher64Seed(v interface{}, seed uint64) uint64 {
 	switch v := v.(type) {
 	case KeyHasher:
-		return Mix64(v.Hash())
+		return Mix64(v.Hash() ^ seed)
 	case string:
-		return Fnv64(v)
+		return WyHash64Seed(v, seed)
 	case int:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case uint:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case uint64:
-		return Mix64(v)
+		return Mix64(v ^ seed)
 	case int64:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case uint32:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case int32:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case uint16:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case int16:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case uint8:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case int8:
-		return Mix64(uint64(v))
+		return Mix64(uint64(v) ^ seed)
 	case float64:
-		return Mix64(math.Float64bits(v))
+		return Mix64(floatBits(v) ^ seed)
 	case float32:
-		return Mix64(uint64(math.Float32bits(v)))
+		return Mix64(floatBits(float64(v)) ^ seed)
 	case fmt.Stringer:
-		return Fnv64(v.String())
+		return WyHash64Seed(v.String(), seed)
 	default:
-		return Fnv64(reflect.ValueOf(v).String())
+		return ReflectHash64(v, seed)
 	}
 }
 
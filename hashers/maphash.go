@@ -0,0 +1,22 @@
+package hashers
+
+import "hash/maphash"
+
+// procSeed seeds hash/maphash, it is random for every process.
+var procSeed = maphash.MakeSeed()
+
+// MapHash64 returns a hash of v built on hash/maphash, it covers the full width of every key kind
+// and is consistent with Go's == equality. KeyHasher overrides it.
+// The hashes are random for every process, they must not be stored, use TypeHasher64Seed for that.
+func MapHash64(v interface{}, seed uint64) uint64 {
+	if kh, ok := v.(KeyHasher); ok {
+		return Mix64(kh.Hash() ^ seed)
+	}
+	return Mix64(comparableHash(v) ^ seed)
+}
+
+// MapHash32 returns MapHash64 folded to 32 bits.
+func MapHash32(v interface{}, seed uint64) uint32 {
+	h := MapHash64(v, seed)
+	return uint32(h ^ h>>32)
+}
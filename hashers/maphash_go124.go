@@ -0,0 +1,10 @@
+//go:build go1.24
+// +build go1.24
+
+package hashers
+
+import "hash/maphash"
+
+func comparableHash(v interface{}) uint64 {
+	return maphash.Comparable(procSeed, v)
+}
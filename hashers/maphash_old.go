@@ -0,0 +1,46 @@
+//go:build !go1.24
+// +build !go1.24
+
+package hashers
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+)
+
+func comparableHash(v interface{}) uint64 {
+	var (
+		mh maphash.Hash
+		b  [8]byte
+	)
+	mh.SetSeed(procSeed)
+
+	switch v := v.(type) {
+	case string:
+		mh.WriteString(v)
+		return mh.Sum64()
+	case int:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+	case uint:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+	case int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+	case uint64:
+		binary.LittleEndian.PutUint64(b[:], v)
+	case int32:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+	case uint32:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+	case float64:
+		binary.LittleEndian.PutUint64(b[:], floatBits(v))
+	case float32:
+		binary.LittleEndian.PutUint64(b[:], floatBits(float64(v)))
+	default:
+		// structs, arrays, pointers and the other kinds.
+		mh.WriteString("reflect")
+		return ReflectHash64(v, mh.Sum64())
+	}
+
+	mh.Write(b[:])
+	return mh.Sum64()
+}
@@ -0,0 +1,32 @@
+package hashers
+
+import "testing"
+
+type fixedHasher struct{ n int }
+
+func (fixedHasher) Hash() uint64 { return 7 }
+
+func TestMapHash(t *testing.T) {
+	pairs := [][2]interface{}{
+		{int64(1), int64(1)<<32 | 1},
+		{uint64(1), uint64(1)<<63 | 1},
+		{1.0, 1.0000000001},
+		{"a", "b"},
+		{point{X: 1}, point{Y: 1}},
+	}
+	for _, p := range pairs {
+		if MapHash64(p[0], 0) == MapHash64(p[1], 0) {
+			t.Errorf("%v and %v collide", p[0], p[1])
+		}
+		if MapHash64(p[0], 0) == MapHash64(p[0], 1) {
+			t.Errorf("%v: the seed is ignored", p[0])
+		}
+	}
+
+	if MapHash64(point{X: 1, Y: 2}, 3) != MapHash64(point{X: 1, Y: 2}, 3) {
+		t.Fatal("equal keys should hash equally")
+	}
+	if MapHash64(fixedHasher{1}, 3) != MapHash64(fixedHasher{2}, 3) {
+		t.Fatal("KeyHasher should override the hash")
+	}
+}
@@ -0,0 +1,447 @@
+package hashers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// A small SMHasher style suite, every hasher is adapted to hash a byte key, integer hashers read
+// the key as a little endian integer.
+// Known weak spots are reported with t.Log instead of failing, so the suite catches regressions in the other
+// hashers while documenting the ones that are weak by design.
+// Mix64to32 passes every check, truncating after Mix64 keeps the distribution, the truncation that hurts is
+// TypeHasher32 dropping the high half of 64-bit integers before mixing.
+// Every hasher used for keys is in the table, once per kind of key it handles differently.
+
+type qualityHasher struct {
+	name   string
+	bits   uint
+	keyLen int // fixed key length for integer hashers, 0 for string hashers
+	fn     func(k []byte) uint64
+
+	// known maps the name of a check to the reason the hasher is expected to fail it.
+	known map[string]string
+}
+
+func u64(k []byte) uint64 {
+	var b [8]byte
+	copy(b[:], k)
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func u32(k []byte) uint32 { return uint32(u64(k)) }
+
+// f64 reads the key as the bits of a float64.
+func f64(k []byte) float64 { return math.Float64frombits(u64(k)) }
+
+// testSeed is a fixed seed for the seeded hashers, so failures are reproducible.
+const testSeed = 0x9e3779b97f4a7c15
+
+const (
+	fnvWeak      = "FNV-1 mixes every byte only once, the low bits depend mostly on the last byte"
+	xorShiftWeak = "the final xorshift makes some output bits flip together"
+	truncateWeak = "64-bit integers are truncated to 32 bits before Mix32"
+	foldWeak     = "floats are folded to 32 bits with b^b>>32 before Mix32, keys with the same bits in both halves collide"
+)
+
+var qualityHashers = []qualityHasher{
+	{
+		name: "Fnv32", bits: 32, fn: func(k []byte) uint64 { return uint64(Fnv32(string(k))) },
+		known: map[string]string{
+			"avalanche":        fnvWeak,
+			"bit-independence": fnvWeak,
+			"sparse":           fnvWeak,
+			"cyclic":           fnvWeak,
+			"sequential":       fnvWeak,
+		},
+	},
+	{
+		name: "Fnv64", bits: 64, fn: func(k []byte) uint64 { return Fnv64(string(k)) },
+		known: map[string]string{
+			"avalanche":        fnvWeak,
+			"bit-independence": fnvWeak,
+			"sparse":           fnvWeak,
+			"cyclic":           fnvWeak,
+		},
+	},
+	{name: "WyHash32", bits: 32, fn: func(k []byte) uint64 { return uint64(WyHash32(string(k))) }},
+	{name: "WyHash64", bits: 64, fn: func(k []byte) uint64 { return WyHash64(string(k)) }},
+	{
+		name: "Mix32", bits: 32, keyLen: 4, fn: func(k []byte) uint64 { return uint64(Mix32(u32(k))) },
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{
+		name: "Mix64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 { return Mix64(u64(k)) },
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{name: "Mix64to32", bits: 32, keyLen: 8, fn: func(k []byte) uint64 { return uint64(Mix64to32(u64(k))) }},
+	{
+		name: "TypeHasher32/int64", bits: 32, keyLen: 8, fn: func(k []byte) uint64 { return uint64(TypeHasher32(int64(u64(k)))) },
+		known: map[string]string{
+			"avalanche":        truncateWeak,
+			"bit-independence": truncateWeak,
+			"sparse":           truncateWeak,
+			"high-bits":        truncateWeak,
+		},
+	},
+	{name: "TypeHasher32/string", bits: 32, fn: func(k []byte) uint64 { return uint64(TypeHasher32(string(k))) }},
+	{
+		name: "TypeHasher32/float64", bits: 32, keyLen: 8, fn: func(k []byte) uint64 { return uint64(TypeHasher32(f64(k))) },
+		known: map[string]string{
+			"bit-independence": xorShiftWeak,
+			"sparse":           foldWeak,
+			"cyclic":           foldWeak,
+		},
+	},
+	{
+		name: "TypeHasher32Seed/int64", bits: 32, keyLen: 8, fn: func(k []byte) uint64 {
+			return uint64(TypeHasher32Seed(int64(u64(k)), testSeed))
+		},
+		known: map[string]string{
+			"avalanche":        truncateWeak,
+			"bit-independence": truncateWeak,
+			"sparse":           truncateWeak,
+			"high-bits":        truncateWeak,
+		},
+	},
+	{name: "TypeHasher32Seed/string", bits: 32, fn: func(k []byte) uint64 { return uint64(TypeHasher32Seed(string(k), testSeed)) }},
+	{
+		name: "TypeHasher32Seed/float64", bits: 32, keyLen: 8, fn: func(k []byte) uint64 {
+			return uint64(TypeHasher32Seed(f64(k), testSeed))
+		},
+		known: map[string]string{
+			"bit-independence": xorShiftWeak,
+			"sparse":           foldWeak,
+			"cyclic":           foldWeak,
+		},
+	},
+	// integers and floats go through Mix64, so they share its weak spot.
+	{
+		name: "TypeHasher64/int64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 { return TypeHasher64(int64(u64(k))) },
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{name: "TypeHasher64/string", bits: 64, fn: func(k []byte) uint64 { return TypeHasher64(string(k)) }},
+	{
+		name: "TypeHasher64/float64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 { return TypeHasher64(f64(k)) },
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{
+		name: "TypeHasher64Seed/int64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 {
+			return TypeHasher64Seed(int64(u64(k)), testSeed)
+		},
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{name: "TypeHasher64Seed/string", bits: 64, fn: func(k []byte) uint64 { return TypeHasher64Seed(string(k), testSeed) }},
+	{
+		name: "TypeHasher64Seed/float64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 {
+			return TypeHasher64Seed(f64(k), testSeed)
+		},
+		known: map[string]string{"bit-independence": xorShiftWeak},
+	},
+	{name: "WyHash32Seed", bits: 32, fn: func(k []byte) uint64 { return uint64(WyHash32Seed(string(k), testSeed)) }},
+	{name: "WyHash64Seed", bits: 64, fn: func(k []byte) uint64 { return WyHash64Seed(string(k), testSeed) }},
+	{name: "MapHash64/int64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 { return MapHash64(int64(u64(k)), 0) }},
+	{name: "MapHash64/string", bits: 64, fn: func(k []byte) uint64 { return MapHash64(string(k), 0) }},
+	{name: "ReflectHash64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 {
+		return ReflectHash64(struct{ A, B uint32 }{uint32(u64(k)), uint32(u64(k) >> 32)}, 0)
+	}},
+	{
+		// real is the low half of the key and imag the high half.
+		name: "ComplexHash64", bits: 64, keyLen: 8, fn: func(k []byte) uint64 {
+			x := u64(k)
+			return ComplexHash64(complex(float64(uint32(x)), float64(x>>32)), 0)
+		},
+	},
+}
+
+type qualityCheck struct {
+	name string
+	fn   func(h *qualityHasher) error
+}
+
+var qualityChecks = []qualityCheck{
+	{"avalanche", checkAvalanche},
+	{"bit-independence", checkBitIndependence},
+	{"sparse", checkSparse},
+	{"cyclic", checkCyclic},
+	{"sequential", checkSequential},
+	{"high-bits", checkHighBits},
+}
+
+func TestHashQuality(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the hash quality suite in short mode")
+	}
+
+	for i := range qualityHashers {
+		h := &qualityHashers[i]
+		t.Run(h.name, func(t *testing.T) {
+			for _, c := range qualityChecks {
+				err := c.fn(h)
+				reason, known := h.known[c.name]
+				switch {
+				case err != nil && known:
+					t.Logf("known weak spot, %s: %v (%s)", c.name, err, reason)
+				case err != nil:
+					t.Errorf("%s: %v", c.name, err)
+				case known:
+					t.Logf("%s passes now, it can be removed from the known weak spots", c.name)
+				}
+			}
+		})
+	}
+}
+
+func (h *qualityHasher) keyBytes() int {
+	if h.keyLen > 0 {
+		return h.keyLen
+	}
+	return 16
+}
+
+// checkAvalanche flips every input bit of random keys, every output bit should flip half of the time.
+func checkAvalanche(h *qualityHasher) error {
+	const trials = 2000
+
+	var (
+		rnd    = rand.New(rand.NewSource(1))
+		n      = h.keyBytes()
+		key    = make([]byte, n)
+		counts = make([][64]int, n*8)
+	)
+
+	for t := 0; t < trials; t++ {
+		rnd.Read(key)
+		base := h.fn(key)
+		for i := 0; i < n*8; i++ {
+			key[i/8] ^= 1 << uint(i%8)
+			d := base ^ h.fn(key)
+			key[i/8] ^= 1 << uint(i%8)
+			for j := uint(0); j < h.bits; j++ {
+				counts[i][j] += int(d >> j & 1)
+			}
+		}
+	}
+
+	var worst float64
+	for i := range counts {
+		for j := uint(0); j < h.bits; j++ {
+			if bias := math.Abs(float64(counts[i][j])/trials-0.5) * 2; bias > worst {
+				worst = bias
+			}
+		}
+	}
+	if worst > 0.15 {
+		return fmt.Errorf("worst bias %.3f", worst)
+	}
+	return nil
+}
+
+// checkBitIndependence checks that pairs of output bits flip independently when an input bit is flipped.
+func checkBitIndependence(h *qualityHasher) error {
+	const trials = 1000
+
+	var (
+		rnd   = rand.New(rand.NewSource(2))
+		n     = h.keyBytes()
+		key   = make([]byte, n)
+		worst float64
+	)
+
+	for i := 0; i < n*8; i += 7 {
+		var (
+			single [64]int
+			pairs  [64][64]int
+		)
+		for t := 0; t < trials; t++ {
+			rnd.Read(key)
+			base := h.fn(key)
+			key[i/8] ^= 1 << uint(i%8)
+			d := base ^ h.fn(key)
+			for j := uint(0); j < h.bits; j++ {
+				if d>>j&1 == 0 {
+					continue
+				}
+				single[j]++
+				for k := j + 1; k < h.bits; k++ {
+					pairs[j][k] += int(d >> k & 1)
+				}
+			}
+		}
+
+		for j := uint(0); j < h.bits; j++ {
+			for k := j + 1; k < h.bits; k++ {
+				pj, pk := float64(single[j])/trials, float64(single[k])/trials
+				cov := float64(pairs[j][k])/trials - pj*pk
+				den := math.Sqrt(pj * (1 - pj) * pk * (1 - pk))
+				if den == 0 {
+					worst = 1
+					continue
+				}
+				if c := math.Abs(cov / den); c > worst {
+					worst = c
+				}
+			}
+		}
+	}
+
+	if worst > 0.2 {
+		return fmt.Errorf("worst correlation %.3f", worst)
+	}
+	return nil
+}
+
+// checkSparse hashes all the keys with at most 2 bits set.
+func checkSparse(h *qualityHasher) error {
+	n := h.keyBytes()
+	var keys [][]byte
+	keys = append(keys, make([]byte, n))
+	for i := 0; i < n*8; i++ {
+		for j := i; j < n*8; j++ {
+			k := make([]byte, n)
+			k[i/8] |= 1 << uint(i%8)
+			k[j/8] |= 1 << uint(j%8)
+			keys = append(keys, k)
+		}
+	}
+	return checkKeys(h, keys)
+}
+
+// checkCyclic hashes keys made of a random cycle of 2 to 8 bytes repeated.
+func checkCyclic(h *qualityHasher) error {
+	var (
+		rnd  = rand.New(rand.NewSource(3))
+		n    = h.keyBytes()
+		seen = map[string]bool{}
+		keys [][]byte
+	)
+	for c := 2; c <= 8; c++ {
+		for i := 0; i < 1000; i++ {
+			cycle := make([]byte, c)
+			rnd.Read(cycle)
+			k := make([]byte, n)
+			for j := range k {
+				k[j] = cycle[j%c]
+			}
+			if !seen[string(k)] {
+				seen[string(k)] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return checkKeys(h, keys)
+}
+
+// checkSequential hashes sequential integers, as decimal strings for string hashers.
+func checkSequential(h *qualityHasher) error {
+	keys := make([][]byte, 0, 20000)
+	for i := 0; i < cap(keys); i++ {
+		if h.keyLen == 0 {
+			keys = append(keys, []byte(strconv.Itoa(i)))
+		} else {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(i))
+			keys = append(keys, b[:h.keyLen])
+		}
+	}
+	return checkKeys(h, keys)
+}
+
+// checkHighBits hashes keys that only differ in the high half of the key.
+func checkHighBits(h *qualityHasher) error {
+	n := h.keyBytes()
+	keys := make([][]byte, 0, 10000)
+	for i := 0; i < cap(keys); i++ {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(i))
+		k := make([]byte, n)
+		copy(k[n/2:], b[:])
+		keys = append(keys, k)
+	}
+	return checkKeys(h, keys)
+}
+
+// checkKeys fails if the keys have more collisions than expected, or if the low 8 bits, used to pick the shard,
+// aren't uniformly distributed.
+func checkKeys(h *qualityHasher, keys [][]byte) error {
+	const buckets = 256
+
+	var (
+		seen   = make(map[uint64]struct{}, len(keys))
+		counts [buckets]int
+		colls  int
+	)
+	for _, k := range keys {
+		v := h.fn(k)
+		if _, ok := seen[v]; ok {
+			colls++
+		}
+		seen[v] = struct{}{}
+		counts[v%buckets]++
+	}
+
+	// expected collisions for a random function, n^2 / 2^(bits+1)
+	exp := float64(len(keys)) * float64(len(keys)) / math.Pow(2, float64(h.bits+1))
+	if float64(colls) > exp*4+3 {
+		return fmt.Errorf("%d collisions in %d keys, expected %.2f", colls, len(keys), exp)
+	}
+
+	var chi2 float64
+	e := float64(len(keys)) / buckets
+	for _, c := range counts {
+		chi2 += (float64(c) - e) * (float64(c) - e) / e
+	}
+	// df = 255, mean 255 and stddev ~22.6, allow 6 stddevs.
+	if chi2 > 255+6*22.6 {
+		return fmt.Errorf("shard distribution chi-square %.1f over %d keys", chi2, len(keys))
+	}
+	return nil
+}
+
+func TestComplexHash64(t *testing.T) {
+	// these used to collide when cmap_if_cmplx.go hashed real(key)+imag(key).
+	pairs := [][2]complex128{
+		{complex(1, 2), complex(2, 1)},
+		{complex(3, 0), complex(0, 3)},
+		{complex(0.25, 0), complex(0.5, 0)},
+		{complex(1.5, 1.5), complex(3, 0)},
+	}
+	for _, p := range pairs {
+		if ComplexHash64(p[0], 0) == ComplexHash64(p[1], 0) {
+			t.Errorf("%v and %v collide", p[0], p[1])
+		}
+	}
+
+	if ComplexHash64(complex(math.Copysign(0, -1), 1), 3) != ComplexHash64(complex(0, 1), 3) {
+		t.Error("-0 and 0 should hash the same")
+	}
+	if ComplexHash64(complex(1, 2), 3) != ReflectHash64(complex(1, 2), 3) {
+		t.Error("ReflectHash64 should match ComplexHash64")
+	}
+}
+
+var qualitySink uint64
+
+func BenchmarkHashers(b *testing.B) {
+	for i := range qualityHashers {
+		h := &qualityHashers[i]
+		sizes := []int{8, 32, 256}
+		if h.keyLen > 0 {
+			sizes = []int{h.keyLen}
+		}
+		for _, n := range sizes {
+			key := make([]byte, n)
+			rand.New(rand.NewSource(4)).Read(key)
+			b.Run(fmt.Sprintf("%s/%d", h.name, n), func(b *testing.B) {
+				b.SetBytes(int64(n))
+				for i := 0; i < b.N; i++ {
+					qualitySink += h.fn(key)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package hashers
+
+import (
+	"math"
+	"reflect"
+	"sync"
+)
+
+// valueHasher hashes a value of a specific type, h is the seed or the hash of the previous fields.
+type valueHasher func(v reflect.Value, h uint64) uint64
+
+// typeHashers caches the valueHasher of every type, map[reflect.Type]valueHasher.
+var typeHashers sync.Map
+
+// ReflectHash64 returns a structural hash of v that is consistent with Go's == equality, it walks struct fields,
+// arrays, interfaces and all the basic kinds. Pointers, channels and unsafe pointers are hashed by address.
+// Types that aren't comparable, like slices, maps and funcs, all hash to the same value per type.
+// The hasher of every type is built once and cached.
+func ReflectHash64(v interface{}, seed uint64) uint64 {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() { // nil
+		return mixBits(seed, 0)
+	}
+	return hasherFor(rv.Type())(rv, seed)
+}
+
+func hasherFor(t reflect.Type) valueHasher {
+	if fn, ok := typeHashers.Load(t); ok {
+		return fn.(valueHasher)
+	}
+	fn, _ := typeHashers.LoadOrStore(t, newValueHasher(t))
+	return fn.(valueHasher)
+}
+
+func newValueHasher(t reflect.Type) valueHasher {
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(v reflect.Value, h uint64) uint64 {
+			if v.Bool() {
+				return mixBits(h, 1)
+			}
+			return mixBits(h, 0)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value, h uint64) uint64 { return mixBits(h, uint64(v.Int())) }
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value, h uint64) uint64 { return mixBits(h, v.Uint()) }
+
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value, h uint64) uint64 { return mixBits(h, floatBits(v.Float())) }
+
+	case reflect.Complex64, reflect.Complex128:
+		return func(v reflect.Value, h uint64) uint64 { return ComplexHash64(v.Complex(), h) }
+
+	case reflect.String:
+		return func(v reflect.Value, h uint64) uint64 { return wyhash(v.String(), h) }
+
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return func(v reflect.Value, h uint64) uint64 { return mixBits(h, uint64(v.Pointer())) }
+
+	case reflect.Interface:
+		return func(v reflect.Value, h uint64) uint64 {
+			if v.IsNil() {
+				return mixBits(h, 0)
+			}
+			ev := v.Elem()
+			return hasherFor(ev.Type())(ev, h)
+		}
+
+	case reflect.Array:
+		n, elem := t.Len(), hasherFor(t.Elem())
+		return func(v reflect.Value, h uint64) uint64 {
+			for i := 0; i < n; i++ {
+				h = elem(v.Index(i), h)
+			}
+			return h
+		}
+
+	case reflect.Struct:
+		type field struct {
+			idx int
+			fn  valueHasher
+		}
+		var fields []field
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.Name != "_" { // blank fields are ignored by ==
+				fields = append(fields, field{i, hasherFor(f.Type)})
+			}
+		}
+		return func(v reflect.Value, h uint64) uint64 {
+			for _, f := range fields {
+				h = f.fn(v.Field(f.idx), h)
+			}
+			return mixBits(h, uint64(len(fields)))
+		}
+
+	default: // not comparable, the map would panic anyway
+		ts := t.String()
+		return func(v reflect.Value, h uint64) uint64 { return wyhash(ts, h) }
+	}
+}
+
+// ComplexHash64 returns a hash of c that mixes the bits of the real and imaginary parts separately,
+// -0 and 0 hash the same since they are equal.
+func ComplexHash64(c complex128, seed uint64) uint64 {
+	return mixBits(mixBits(seed, floatBits(real(c))), floatBits(imag(c)))
+}
+
+func mixBits(h, x uint64) uint64 {
+	return wymix(h^wyp0, x^wyp1)
+}
+
+// floatBits returns the bits of f with -0 converted to 0, since they are equal.
+func floatBits(f float64) uint64 {
+	if f == 0 {
+		return 0
+	}
+	return math.Float64bits(f)
+}
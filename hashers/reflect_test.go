@@ -0,0 +1,118 @@
+package hashers
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+	_    int
+}
+
+type userID int
+
+type key struct {
+	Name  string
+	Pt    point
+	Arr   [3]uint16
+	Ptr   *int
+	Any   interface{}
+	On    bool
+	F     float64
+	C     complex128
+	inner struct{ s string }
+}
+
+func TestReflectHashEqualKeys(t *testing.T) {
+	n := 42
+	mk := func() key {
+		k := key{
+			Name: "a",
+			Pt:   point{X: 1, Y: 2},
+			Arr:  [3]uint16{1, 2, 3},
+			Ptr:  &n,
+			Any:  point{X: 3},
+			On:   true,
+			F:    math.Copysign(0, -1),
+			C:    complex(1, math.Copysign(0, -1)),
+		}
+		k.inner.s = "x" + strconv.Itoa(1)
+		return k
+	}
+
+	a, b := mk(), mk()
+	b.F, b.C = 0, 1
+	if a != b {
+		t.Fatal("the keys should be equal")
+	}
+
+	for _, seed := range []uint64{0, 1, NewSeed()} {
+		if ReflectHash64(a, seed) != ReflectHash64(b, seed) || TypeHasher32Seed(a, seed) != TypeHasher32Seed(b, seed) {
+			t.Fatalf("equal keys should hash equally with seed %d", seed)
+		}
+	}
+
+	m := 42
+	c := mk()
+	c.Ptr = &m // different pointer, same value
+	d := mk()
+	d.inner.s = "y"
+	for _, k := range []key{c, d} {
+		if a == k || ReflectHash64(a, 0) == ReflectHash64(k, 0) {
+			t.Fatalf("%+v should hash differently", k)
+		}
+	}
+
+	if _, ok := typeHashers.Load(reflect.TypeOf(a)); !ok {
+		t.Fatal("the hasher should be cached")
+	}
+}
+
+func TestReflectHashDistribution(t *testing.T) {
+	const shards = 64
+
+	seen := map[uint32]bool{}
+	for i := 0; i < shards*10; i++ {
+		seen[TypeHasher32(point{X: i, Y: -i})&(shards-1)] = true
+		seen[TypeHasher32([2]int8{int8(i), 1})&(shards-1)] = true
+	}
+	if len(seen) < shards*3/4 {
+		t.Fatalf("struct keys only use %d of %d shards", len(seen), shards)
+	}
+
+	if TypeHasher32(true) == TypeHasher32(false) {
+		t.Fatal("bools should hash differently")
+	}
+	if TypeHasher32(userID(1)) == TypeHasher32(userID(2)) {
+		t.Fatal("named ints should hash by value")
+	}
+	if TypeHasher32(complex(1, 2)) == TypeHasher32(complex(2, 1)) {
+		t.Fatal("complex numbers should hash both parts")
+	}
+	if TypeHasher32(nil) != TypeHasher32(nil) || ReflectHash64([]int{1}, 0) != ReflectHash64([]int{2}, 0) {
+		t.Fatal("nil and uncomparable types should hash consistently")
+	}
+}
+
+func TestTypeHasherFloats(t *testing.T) {
+	for _, seed := range []uint64{0, 1, NewSeed()} {
+		if TypeHasher32Seed(math.Copysign(0, -1), seed) != TypeHasher32Seed(0.0, seed) ||
+			TypeHasher32Seed(float32(math.Copysign(0, -1)), seed) != TypeHasher32Seed(float32(0), seed) ||
+			TypeHasher64Seed(math.Copysign(0, -1), seed) != TypeHasher64Seed(0.0, seed) {
+			t.Fatalf("-0 and 0 should hash the same with seed %d", seed)
+		}
+	}
+
+	// round floats share their low 32 bits, they all used to collide.
+	seen := map[uint32]float64{}
+	for _, f := range []float64{1, 2, 3, 0.5, 0.25, 10, 100, 1e6, -1, 1 << 40} {
+		h := TypeHasher32(f)
+		if o, ok := seen[h]; ok {
+			t.Fatalf("%v and %v collide", f, o)
+		}
+		seen[h] = f
+	}
+}
@@ -0,0 +1,18 @@
+package hashers
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// NewSeed returns a random seed for the seeded hashers, so keys chosen by an attacker can't be made to collide
+// without knowing the seed.
+func NewSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms, this is better than panicking if it ever does.
+		return Mix64(uint64(time.Now().UnixNano()))
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
@@ -0,0 +1,99 @@
+package hashers
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// wyhash (https://github.com/wangyi-fudan/wyhash) constants.
+const (
+	wyp0 = 0xa0761d6478bd642f
+	wyp1 = 0xe7037ed1a0b428db
+	wyp2 = 0x8ebc6af09c88c6e3
+	wyp3 = 0x589965cc75374cc3
+)
+
+// WyHash64 returns a 64-bit wyhash of a string, it reads 8 bytes at a time and is much faster than Fnv64 for long keys.
+func WyHash64(s string) uint64 {
+	return wyhash(s, 0)
+}
+
+// WyHash32 returns WyHash64 folded to 32 bits.
+func WyHash32(s string) uint32 {
+	return WyHash32Seed(s, 0)
+}
+
+// WyHash64Seed is WyHash64 with a seed, see NewSeed.
+func WyHash64Seed(s string, seed uint64) uint64 {
+	return wyhash(s, seed)
+}
+
+// WyHash32Seed is WyHash32 with a seed, see NewSeed.
+func WyHash32Seed(s string, seed uint64) uint32 {
+	h := wyhash(s, seed)
+	return uint32(h ^ h>>32)
+}
+
+func wyhash(s string, seed uint64) uint64 {
+	var a, b uint64
+	ln := len(s)
+	seed ^= wymix(seed^wyp0, wyp1)
+
+	switch {
+	case ln == 0:
+	case ln < 4:
+		a = uint64(s[0])<<16 | uint64(s[ln>>1])<<8 | uint64(s[ln-1])
+	case ln <= 16:
+		off := (ln >> 3) << 2
+		a = wyr4(s)<<32 | wyr4(s[off:])
+		b = wyr4(s[ln-4:])<<32 | wyr4(s[ln-4-off:])
+	default:
+		p := s
+		if len(p) > 48 {
+			see1, see2 := seed, seed
+			for len(p) > 48 {
+				seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+				see1 = wymix(wyr8(p[16:])^wyp2, wyr8(p[24:])^see1)
+				see2 = wymix(wyr8(p[32:])^wyp3, wyr8(p[40:])^see2)
+				p = p[48:]
+			}
+			seed ^= see1 ^ see2
+		}
+		for len(p) > 16 {
+			seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+			p = p[16:]
+		}
+		// the last 16 bytes of the key, they may overlap with bytes that were already mixed.
+		a, b = wyr8(s[ln-16:]), wyr8(s[ln-8:])
+	}
+
+	hi, lo := bits.Mul64(a^wyp1, b^seed)
+	return wymix(lo^wyp0^uint64(ln), hi^wyp1)
+}
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+// wyr8 and wyr4 read little endian integers, the compiler merges the byte loads into a single load.
+func wyr8(s string) uint64 {
+	_ = s[7] // bounds check hint
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+func wyr4(s string) uint64 {
+	_ = s[3] // bounds check hint
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24
+}
+
+// WyHash64SeedBytes is WyHash64Seed for a []byte, it doesn't copy or allocate.
+func WyHash64SeedBytes(b []byte, seed uint64) uint64 {
+	return wyhash(bytesToString(b), seed)
+}
+
+// bytesToString returns a string that shares b's memory, it must not outlive b or be used after b is modified.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
@@ -0,0 +1,114 @@
+package hashers
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWyHash(t *testing.T) {
+	// test vectors from the reference implementation, the seed is the index.
+	vectors := []struct {
+		s string
+		h uint64
+	}{
+		{"", 0x0409638ee2bde459},
+		{"a", 0xa8412d091b5fe0a9},
+		{"abc", 0x32dd92e4b2915153},
+		{"message digest", 0x8619124089a3a16b},
+		{"abcdefghijklmnopqrstuvwxyz", 0x7a43afb61d7f5f40},
+		{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", 0xff42329b90e50d58},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", 0xc39cab13b115aad3},
+	}
+
+	for i, v := range vectors {
+		if h := wyhash(v.s, uint64(i)); h != v.h {
+			t.Errorf("wyhash(%q, %d) = %#x, expected %#x", v.s, i, h, v.h)
+		}
+	}
+
+	// every length hits a different read path, none of them should collide.
+	s := strings.Repeat("abcdefghijklmnopqrstuvwxyz0123456789", 10)
+	seen := map[uint64]int{}
+	for i := 0; i <= len(s); i++ {
+		h := WyHash64(s[:i])
+		if j, ok := seen[h]; ok {
+			t.Fatalf("lengths %d and %d collide", i, j)
+		}
+		seen[h] = i
+	}
+}
+
+func TestWyHash32Distribution(t *testing.T) {
+	const (
+		shards = 256
+		keys   = shards * 100
+	)
+
+	prefix := strings.Repeat("/api/v1/users/sessions/", 8)
+	for name, fn := range map[string]func(string) uint32{"WyHash32": WyHash32, "TypeHasher32": func(s string) uint32 { return TypeHasher32(s) }} {
+		var counts [shards]int
+		for i := 0; i < keys; i++ {
+			counts[fn(prefix+strconv.Itoa(i))&(shards-1)]++
+		}
+		for i, c := range counts {
+			if c < 50 || c > 150 {
+				t.Fatalf("%s: shard %d has %d keys, expected ~100", name, i, c)
+			}
+		}
+	}
+}
+
+var benchKeys = func() (keys []string) {
+	for _, n := range []int{8, 32, 200} {
+		keys = append(keys, strings.Repeat("k", n-3)+"123")
+	}
+	return
+}()
+
+func BenchmarkFnv32(b *testing.B) {
+	for _, k := range benchKeys {
+		b.Run(strconv.Itoa(len(k)), func(b *testing.B) {
+			b.SetBytes(int64(len(k)))
+			for i := 0; i < b.N; i++ {
+				Fnv32(k)
+			}
+		})
+	}
+}
+
+func BenchmarkWyHash32(b *testing.B) {
+	for _, k := range benchKeys {
+		b.Run(strconv.Itoa(len(k)), func(b *testing.B) {
+			b.SetBytes(int64(len(k)))
+			for i := 0; i < b.N; i++ {
+				WyHash32(k)
+			}
+		})
+	}
+}
+
+func TestSeeded(t *testing.T) {
+	if WyHash32Seed("abc", 0) != WyHash32("abc") || TypeHasher32Seed(42, 0) != TypeHasher32(42) {
+		t.Fatal("seed 0 should match the unseeded hashers")
+	}
+
+	for _, v := range []interface{}{"abc", 42, uint8(1), 1.5} {
+		if TypeHasher32Seed(v, 1) == TypeHasher32Seed(v, 2) {
+			t.Fatalf("%v: different seeds should give different hashes", v)
+		}
+	}
+
+	if NewSeed() == NewSeed() {
+		t.Fatal("expected random seeds")
+	}
+}
+
+func TestWyHashBytes(t *testing.T) {
+	s := strings.Repeat("0123456789", 10)
+	for i := 0; i <= len(s); i++ {
+		if WyHash64SeedBytes([]byte(s[:i]), 3) != WyHash64Seed(s[:i], 3) {
+			t.Fatalf("%d: the []byte and string hashes differ", i)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// +build genx
+
+package cmap
+
+import "sync"
+
+// HookMode controls when a hook runs.
+type HookMode uint8
+
+const (
+	// HookAfterUnlock runs the hook synchronously on the mutating goroutine after the shard is unlocked,
+	// hooks of concurrent mutations of the same key may run out of order.
+	HookAfterUnlock HookMode = iota
+	// HookLocked runs the hook while the shard is locked, it is NOT safe to call other cmap funcs inside the hook.
+	HookLocked
+	// HookAsync queues the event after the shard is unlocked and runs the hook on its own goroutine,
+	// in the order the events were queued. The mutating goroutine only blocks if the hook is more than
+	// hookQueueSize events behind. Events that are still queued when the hook is removed are dropped.
+	HookAsync
+)
+
+// hookQueueSize is the number of events queued for a HookAsync hook before the mutations block.
+const hookQueueSize = 1024
+
+// OnSet registers fn to be called after every Set, SetIfNotExists, Update and Swap.
+// The returned func removes the hook.
+func (cm *CMap) OnSet(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventSet, EventUpdate, EventSwap)
+}
+
+// OnDelete registers fn to be called after every Delete and DeleteAndGet that removed a key.
+// The returned func removes the hook.
+func (cm *CMap) OnDelete(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventDelete)
+}
+
+// OnEvict registers fn to be called after every Evict that removed a key.
+// The returned func removes the hook.
+func (cm *CMap) OnEvict(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventEvict)
+}
+
+// SetHookPanicHandler sets the func called with the event and the recovered value when a hook panics,
+// without one the panic is recovered and dropped silently.
+func (cm *CMap) SetHookPanicHandler(fn func(ev Event, v interface{})) {
+	cm.updateObservers(func(ob *observers) { ob.onPanic = fn })
+}
+
+// Evict removes the key like DeleteAndGet, but it is reported as EventEvict instead of EventDelete.
+// CMap never evicts keys by itself, it is meant for callers implementing expiration or size limits
+// so hooks and watchers can tell evictions and deletes apart.
+func (cm *CMap) Evict(key KT) (old VT, ok bool) {
+	var zero VT
+	return cm.mutate(cm.shardFor(key), cm.observers(), EventEvict, key, zero, nil)
+}
+
+type hook struct {
+	fn   func(ev Event)
+	mode HookMode
+	ops  [EventEvict + 1]bool
+
+	// HookAsync only
+	queue chan Event
+	done  chan struct{}
+}
+
+func (cm *CMap) addHook(fn func(ev Event), mode HookMode, ops ...EventOp) func() {
+	h := &hook{fn: fn, mode: mode}
+	for _, op := range ops {
+		h.ops[op] = true
+	}
+
+	if mode == HookAsync {
+		h.queue, h.done = make(chan Event, hookQueueSize), make(chan struct{})
+		go cm.runAsyncHook(h)
+	}
+
+	cm.updateObservers(func(ob *observers) {
+		ob.hooks = append(ob.hooks[:len(ob.hooks):len(ob.hooks)], h)
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cm.updateObservers(func(ob *observers) {
+				hs := make([]*hook, 0, len(ob.hooks))
+				for _, oh := range ob.hooks {
+					if oh != h {
+						hs = append(hs, oh)
+					}
+				}
+				ob.hooks = hs
+			})
+			if h.done != nil {
+				close(h.done)
+			}
+		})
+	}
+}
+
+func (cm *CMap) runAsyncHook(h *hook) {
+	for {
+		select {
+		case ev := <-h.queue:
+			var onPanic func(ev Event, v interface{})
+			if ob := cm.observers(); ob != nil {
+				onPanic = ob.onPanic
+			}
+			h.run(&ev, onPanic)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// runHooks runs the hooks of the mode, the HookAfterUnlock pass also queues the events of the HookAsync hooks.
+func (ob *observers) runHooks(mode HookMode, ev *Event) {
+	for _, h := range ob.hooks {
+		if !h.ops[ev.Op] {
+			continue
+		}
+		switch {
+		case h.mode == mode:
+			h.run(ev, ob.onPanic)
+		case h.mode == HookAsync && mode == HookAfterUnlock:
+			select {
+			case h.queue <- *ev:
+			case <-h.done:
+			}
+		}
+	}
+}
+
+func (h *hook) run(ev *Event, onPanic func(ev Event, v interface{})) {
+	defer func() {
+		if v := recover(); v != nil {
+			if onPanic != nil {
+				onPanic(*ev, v)
+			}
+		}
+	}()
+	h.fn(*ev)
+}
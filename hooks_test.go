@@ -0,0 +1,105 @@
+package cmap_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestHooks(t *testing.T) {
+	cm := cmap.New()
+
+	var sets, deletes, evicts, panics int32
+	var recovered interface{}
+	cm.SetHookPanicHandler(func(ev cmap.Event, v interface{}) {
+		atomic.AddInt32(&panics, 1)
+		recovered = v
+	})
+
+	removeSet := cm.OnSet(func(ev cmap.Event) { atomic.AddInt32(&sets, 1) }, cmap.HookLocked)
+	cm.OnDelete(func(ev cmap.Event) {
+		atomic.AddInt32(&deletes, 1)
+		if cm.Has(ev.Key) {
+			t.Errorf("%v should've been deleted", ev.Key)
+		}
+	}, cmap.HookAfterUnlock)
+	cm.OnEvict(func(ev cmap.Event) {
+		atomic.AddInt32(&evicts, 1)
+		panic("evict")
+	}, cmap.HookAfterUnlock)
+
+	cm.Set(1, 1)
+	cm.Swap(1, 2)
+	cm.Update(1, func(old interface{}) interface{} { return old.(int) + 1 })
+	cm.SetIfNotExists(1, 0) // not set, no hook
+	cm.Delete(1)
+	cm.Delete(1) // not found, no hook
+
+	cm.Set(2, 2)
+	if v, ok := cm.Evict(2); !ok || v != 2 {
+		t.Fatalf("unexpected Evict result: %v %v", v, ok)
+	}
+	if _, ok := cm.Evict(2); ok {
+		t.Fatal("2 shouldn't exist")
+	}
+
+	if sets != 4 || deletes != 1 || evicts != 1 || panics != 1 || recovered != "evict" {
+		t.Fatalf("unexpected counts: sets %d, deletes %d, evicts %d, panics %d (%v)", sets, deletes, evicts, panics, recovered)
+	}
+
+	removeSet()
+	removeSet()
+	cm.Set(3, 3)
+	if sets != 4 {
+		t.Fatalf("removed hook was called: %d", sets)
+	}
+
+	// without a handler the panic is dropped
+	cm.SetHookPanicHandler(nil)
+	cm.Set(4, 4)
+	if _, ok := cm.Evict(4); !ok || evicts != 2 || panics != 1 {
+		t.Fatalf("unexpected counts: evicts %d, panics %d", evicts, panics)
+	}
+}
+
+func TestHooksAsync(t *testing.T) {
+	cm := cmap.New()
+
+	var (
+		got    = make(chan cmap.Event, 100)
+		panics = make(chan interface{}, 1)
+	)
+	cm.SetHookPanicHandler(func(ev cmap.Event, v interface{}) { panics <- v })
+
+	remove := cm.OnSet(func(ev cmap.Event) {
+		if ev.NewValue == "panic" {
+			panic("async")
+		}
+		cm.Get(ev.Key) // not running under the shard lock
+		got <- ev
+	}, cmap.HookAsync)
+
+	for i := 0; i < 100; i++ {
+		cm.Set("k", i)
+	}
+	for i := 0; i < 100; i++ {
+		if ev := <-got; ev.NewValue != i {
+			t.Fatalf("%d: expected the events in order, got %v", i, ev.NewValue)
+		}
+	}
+
+	cm.Set("k", "panic")
+	if v := <-panics; v != "async" {
+		t.Fatalf("unexpected panic value: %v", v)
+	}
+
+	remove()
+	cm.Set("k", 0)
+	select {
+	case ev := <-got:
+		t.Fatalf("removed hook was called: %+v", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,215 @@
+// +build genx
+
+package cmap
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/OneOfOne/cmap/stats"
+)
+
+// HotKeysConfig controls the hot key tracker, zero values are replaced by the defaults.
+type HotKeysConfig struct {
+	// SampleRate is how many accesses per shard are counted for every sampled one, default 16.
+	SampleRate int
+	// TopK is the number of hot keys to keep per shard, default 8.
+	TopK int
+	// SketchWidth and SketchDepth are the dimensions of the per-shard count-min sketch, default 256x4.
+	SketchWidth int
+	SketchDepth int
+}
+
+// HotKey is a key with its estimated number of accesses and the access rate per second.
+type HotKey struct {
+	Key   KT
+	Hits  uint64
+	Rate  float64
+	Shard int
+}
+
+// HotShard is a shard with its number of accesses and the access rate per second.
+type HotShard struct {
+	Shard int
+	Hits  uint64
+	Rate  float64
+}
+
+// EnableHotKeys starts tracking key and shard accesses, it resets the tracker if it was already enabled.
+// Passing a nil cfg uses the defaults.
+func (cm *CMap) EnableHotKeys(cfg *HotKeysConfig) {
+	var c HotKeysConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.SampleRate < 1 {
+		c.SampleRate = 16
+	}
+	if c.TopK < 1 {
+		c.TopK = 8
+	}
+	if c.SketchWidth < 1 {
+		c.SketchWidth = 256
+	}
+	if c.SketchDepth < 1 {
+		c.SketchDepth = 4
+	}
+
+	cm.hot.Store(newHotTracker(len(cm.shards), c))
+}
+
+// DisableHotKeys stops tracking accesses and releases the tracker.
+func (cm *CMap) DisableHotKeys() {
+	cm.hot.Store((*hotTracker)(nil))
+}
+
+// HotKeys returns up to n of the most accessed keys, sorted by the number of hits.
+// Returns nil if hot key tracking isn't enabled.
+func (cm *CMap) HotKeys(n int) []HotKey {
+	ht := cm.hotTracker()
+	if ht == nil {
+		return nil
+	}
+
+	secs := time.Since(ht.started).Seconds()
+	out := []HotKey{}
+	for i := range ht.shards {
+		hs := &ht.shards[i]
+		hs.mux.Lock()
+		for _, e := range hs.top.entries {
+			hits := uint64(e.hits) * ht.rate
+			out = append(out, HotKey{Key: e.key, Hits: hits, Rate: float64(hits) / secs, Shard: i})
+		}
+		hs.mux.Unlock()
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// HotShards returns up to n of the most accessed shards, sorted by the number of hits.
+// Returns nil if hot key tracking isn't enabled.
+func (cm *CMap) HotShards(n int) []HotShard {
+	ht := cm.hotTracker()
+	if ht == nil {
+		return nil
+	}
+
+	secs := time.Since(ht.started).Seconds()
+	out := make([]HotShard, len(ht.shards))
+	for i := range ht.shards {
+		hits := atomic.LoadUint64(&ht.shards[i].hits)
+		out[i] = HotShard{Shard: i, Hits: hits, Rate: float64(hits) / secs}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func (cm *CMap) hotTracker() *hotTracker {
+	ht, _ := cm.hot.Load().(*hotTracker)
+	return ht
+}
+
+type hotTracker struct {
+	shards  []hotShard
+	rate    uint64
+	started time.Time
+	cfg     HotKeysConfig
+}
+
+func newHotTracker(shards int, c HotKeysConfig) *hotTracker {
+	ht := &hotTracker{
+		shards:  make([]hotShard, shards),
+		rate:    uint64(c.SampleRate),
+		started: time.Now(),
+		cfg:     c,
+	}
+	for i := range ht.shards {
+		ht.shards[i].sketch = stats.NewCountMin(c.SketchWidth, c.SketchDepth)
+		ht.shards[i].top = hotHeap{k: c.TopK, idx: make(map[KT]int, c.TopK)}
+	}
+	return ht
+}
+
+// record counts an access to the shard and samples the key into the shard's sketch.
+func (ht *hotTracker) record(shard int, h uint64, key KT) {
+	hs := &ht.shards[shard]
+	if atomic.AddUint64(&hs.hits, 1)%ht.rate != 0 {
+		return
+	}
+
+	hs.mux.Lock()
+	hs.top.offer(key, hs.sketch.Add(h, 1))
+	hs.mux.Unlock()
+}
+
+type hotShard struct {
+	hits uint64 // must be first for atomic alignment on 32bit platforms
+
+	mux    sync.Mutex
+	sketch *stats.CountMin
+	top    hotHeap
+}
+
+type hotEntry struct {
+	key  KT
+	hits uint32
+}
+
+// hotHeap is a min-heap of the top k entries, the root is the least accessed one.
+type hotHeap struct {
+	entries []hotEntry
+	idx     map[KT]int
+	k       int
+}
+
+func (hh *hotHeap) offer(key KT, hits uint32) {
+	if i, ok := hh.idx[key]; ok {
+		hh.entries[i].hits = hits
+		heap.Fix(hh, i)
+		return
+	}
+
+	if len(hh.entries) < hh.k {
+		heap.Push(hh, hotEntry{key, hits})
+		return
+	}
+
+	if hits > hh.entries[0].hits {
+		delete(hh.idx, hh.entries[0].key)
+		hh.entries[0] = hotEntry{key, hits}
+		hh.idx[key] = 0
+		heap.Fix(hh, 0)
+	}
+}
+
+func (hh *hotHeap) Len() int           { return len(hh.entries) }
+func (hh *hotHeap) Less(i, j int) bool { return hh.entries[i].hits < hh.entries[j].hits }
+
+func (hh *hotHeap) Swap(i, j int) {
+	hh.entries[i], hh.entries[j] = hh.entries[j], hh.entries[i]
+	hh.idx[hh.entries[i].key], hh.idx[hh.entries[j].key] = i, j
+}
+
+func (hh *hotHeap) Push(x interface{}) {
+	e := x.(hotEntry)
+	hh.idx[e.key] = len(hh.entries)
+	hh.entries = append(hh.entries, e)
+}
+
+func (hh *hotHeap) Pop() interface{} {
+	e := hh.entries[len(hh.entries)-1]
+	hh.entries = hh.entries[:len(hh.entries)-1]
+	delete(hh.idx, e.key)
+	return e
+}
@@ -0,0 +1,40 @@
+package cmap_test
+
+import (
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestHotKeys(t *testing.T) {
+	cm := cmap.NewSize(16)
+	if cm.HotKeys(1) != nil || cm.HotShards(1) != nil {
+		t.Fatal("expected nil when hot keys aren't tracked")
+	}
+
+	cm.EnableHotKeys(&cmap.HotKeysConfig{SampleRate: 1, TopK: 4})
+	for i := 0; i < 1000; i++ {
+		cm.Set(i, i)
+	}
+	for i := 0; i < 5000; i++ {
+		cm.Get("hot")
+	}
+
+	hk := cm.HotKeys(1)
+	if len(hk) != 1 || hk[0].Key != "hot" || hk[0].Hits < 5000 {
+		t.Fatalf("unexpected hot keys: %+v", hk)
+	}
+	hs := cm.HotShards(1)
+	if len(hs) != 1 || hs[0].Shard != hk[0].Shard || hs[0].Hits < 5000 || hs[0].Rate <= 0 {
+		t.Fatalf("unexpected hot shards: %+v", hs)
+	}
+
+	if all := cm.HotShards(0); len(all) != cm.NumShards() {
+		t.Fatalf("expected %d shards, got %d", cm.NumShards(), len(all))
+	}
+
+	cm.DisableHotKeys()
+	if cm.HotKeys(1) != nil {
+		t.Fatal("expected nil after DisableHotKeys")
+	}
+}
@@ -0,0 +1,178 @@
+// +build genx
+
+package cmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+// Generations are read from a clock shared by all the shards of a CMap, every write lock of a shard stores the
+// current generation in it, and Checkpoint advances the clock, so shards modified after a checkpoint have a
+// generation >= the checkpoint.
+
+func newClock() *uint64 {
+	c := uint64(1)
+	return &c
+}
+
+// touch marks the map as modified in the current generation, it must be called while the map is write-locked.
+func (lm *LMap) touch() {
+	if lm.clock != nil {
+		atomic.StoreUint64(&lm.gen, atomic.LoadUint64(lm.clock))
+	}
+}
+
+// Generation returns the generation of the last modification of the map, see CMap.Checkpoint.
+// It is conservative, operations that lock the map without modifying it, like deleting a missing key, still count.
+func (lm *LMap) Generation() uint64 {
+	return atomic.LoadUint64(&lm.gen)
+}
+
+// Checkpoint advances the generation of the map and returns it, shards modified after Checkpoint returns
+// will be saved by SaveIncremental(w, gen).
+// Usage:
+//
+//	gen := cm.Checkpoint()
+//	cm.SaveTo(base)
+//	gen, _ = cm.SaveIncremental(inc1, gen)
+//	gen, _ = cm.SaveIncremental(inc2, gen)
+func (cm *CMap) Checkpoint() uint64 {
+	return atomic.AddUint64(cm.clock, 1)
+}
+
+// SaveIncremental writes a snapshot of the shards modified since the checkpoint sinceGen, it returns the next
+// checkpoint to pass to the following SaveIncremental.
+// Shards are written whole, so deleted keys are dropped when the snapshot is applied with ApplyIncremental,
+// and SaveIncremental(w, 0) writes every shard.
+// It uses the default codecs for the key and value types, see SaveToWith.
+func (cm *CMap) SaveIncremental(w io.Writer, sinceGen uint64) (next uint64, err error) {
+	var (
+		k KT
+		v VT
+	)
+	return cm.SaveIncrementalWith(w, sinceGen, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v))
+}
+
+// SaveIncrementalWith is like SaveIncremental but uses the specific codecs.
+func (cm *CMap) SaveIncrementalWith(w io.Writer, sinceGen uint64, kc, vc snapshot.Codec) (next uint64, err error) {
+	next = cm.Checkpoint()
+
+	sw, err := snapshot.NewWriterSeed(w, kc, vc, len(cm.shards), cm.Len(), cm.seed)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, lm := range cm.shards {
+		// the generation is checked under the lock, so a shard is either skipped before a write locks it,
+		// and that write gets a generation >= next, or written with all the writes that happened before.
+		lm.rlock()
+		if lm.Generation() >= sinceGen {
+			err = sw.WriteShard(i, lm.snapshotLocked)
+		}
+		lm.l.RUnlock()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = sw.Close(); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+var (
+	// ErrSeedMismatch is returned when an incremental snapshot was written by a map with a different hash seed.
+	ErrSeedMismatch = errors.New("cmap: the snapshot was written by a map with a different hash seed")
+	// ErrHashMismatch is returned when a key of an incremental snapshot doesn't belong to its shard, because the
+	// snapshot was written with a different hasher, ex. by another process when the hasher is built on hash/maphash.
+	ErrHashMismatch = errors.New("cmap: the snapshot was written with a different hasher")
+)
+
+// ShardCountError is returned when an incremental snapshot doesn't have the same number of shards as the map.
+type ShardCountError struct {
+	Expected, Got int
+}
+
+func (e *ShardCountError) Error() string {
+	return fmt.Sprintf("cmap: expected a snapshot with %d shards, got %d", e.Expected, e.Got)
+}
+
+// ApplyIncremental replaces the shards of the map with the ones in a snapshot written by SaveIncremental,
+// the map must have the same number of shards, hash seed and hasher as the map that wrote it, ex. one returned by
+// Restore or LoadFrom in the same process.
+// Shards can't be replaced when the keys hash differently, since the keys deleted from them can't be told apart,
+// so snapshots written by another process with a hasher built on hash/maphash, like the default hasher of
+// cmap.CMap, return ErrHashMismatch, use Restore for them.
+// Every shard is decoded before it is replaced, so it is applied whole or not at all, however an error may leave
+// the map with only some of the shards of the snapshot applied.
+// It doesn't notify watchers, hooks or the changelog.
+func (cm *CMap) ApplyIncremental(r io.Reader) error {
+	sr, err := incrementalReader(r, len(cm.shards), cm.seed)
+	if err != nil {
+		return err
+	}
+
+	mask := uint64(len(cm.shards) - 1)
+	return readShards(sr, func(idx int, m map[KT]VT) error {
+		for key := range m {
+			if hasher(key, cm.seed)&mask != uint64(idx) {
+				return ErrHashMismatch
+			}
+		}
+
+		lm := cm.shards[idx]
+		lm.lock()
+		lm.m = m
+		lm.l.Unlock()
+		return nil
+	})
+}
+
+// Restore loads a base snapshot written by SaveTo and applies a chain of incremental snapshots written by the same
+// map on top of it in order.
+// The shards are replaced in the layout of the map that wrote the snapshots, then the keys are put in the shards they
+// hash to, so unlike ApplyIncremental, it restores snapshots written by another process.
+func Restore(base io.Reader, incrementals ...io.Reader) (*CMap, error) {
+	shards, seed, err := readFull(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range incrementals {
+		var sr *snapshot.Reader
+		if sr, err = incrementalReader(r, len(shards), seed); err == nil {
+			err = readShards(sr, func(idx int, m map[KT]VT) error {
+				shards[idx] = m
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cmap: incremental snapshot %d: %w", i, err)
+		}
+	}
+
+	return fromShards(shards, seed), nil
+}
+
+// incrementalReader reads the header of an incremental snapshot and checks it matches a map with the shard count
+// and seed.
+func incrementalReader(r io.Reader, shards int, seed uint64) (*snapshot.Reader, error) {
+	sr, err := snapshot.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(sr.Shards) != shards {
+		return nil, &ShardCountError{Expected: shards, Got: int(sr.Shards)}
+	}
+	if sr.Seed != seed {
+		return nil, ErrSeedMismatch
+	}
+	return sr, nil
+}
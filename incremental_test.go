@@ -0,0 +1,279 @@
+package cmap_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+func countShards(t *testing.T, p []byte) (n int) {
+	sr, err := snapshot.NewReader(bytes.NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, err = sr.ReadShard(func(k, v interface{}) error { return nil }); err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+}
+
+func toMap(cm *cmap.CMap) map[interface{}]interface{} {
+	m := map[interface{}]interface{}{}
+	cm.ForEach(func(k, v interface{}) bool {
+		m[k] = v
+		return true
+	})
+	return m
+}
+
+func TestIncremental(t *testing.T) {
+	cm := cmap.NewSize(64)
+	for i := 0; i < 1000; i++ {
+		cm.Set(i, i)
+	}
+
+	var base bytes.Buffer
+	gen := cm.Checkpoint()
+	if err := cm.SaveTo(&base); err != nil {
+		t.Fatal(err)
+	}
+
+	// the number of shards the keys are in depends on the random hash seed.
+	shardsOf := func(keys ...interface{}) int {
+		m := map[*cmap.LMap]bool{}
+		for _, k := range keys {
+			m[cm.ShardForKey(k)] = true
+		}
+		return len(m)
+	}
+
+	var incs []*bytes.Buffer
+	save := func(shards int) {
+		var buf bytes.Buffer
+		var err error
+		if gen, err = cm.SaveIncremental(&buf, gen); err != nil {
+			t.Fatal(err)
+		}
+		if n := countShards(t, buf.Bytes()); n != shards {
+			t.Fatalf("expected %d shards, got %d", shards, n)
+		}
+		incs = append(incs, &buf)
+	}
+
+	save(0)
+
+	cm.Set(1, "one")
+	cm.Delete(2)
+	save(shardsOf(1, 2))
+
+	cm.Set(1, "uno")
+	cm.Update(3000, func(interface{}) interface{} { return "new" })
+	cm.SetBatch([]cmap.KV{{Key: 4, Value: "four"}})
+	save(shardsOf(1, 3000, 4))
+
+	readers := []io.Reader{}
+	for _, buf := range incs {
+		readers = append(readers, bytes.NewReader(buf.Bytes()))
+	}
+
+	rcm, err := cmap.Restore(bytes.NewReader(base.Bytes()), readers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(toMap(rcm), toMap(cm)) {
+		t.Fatal("the restored map doesn't match")
+	}
+
+	var all bytes.Buffer
+	if _, err = cm.SaveIncremental(&all, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := countShards(t, all.Bytes()); n != 64 {
+		t.Fatalf("expected all the shards, got %d", n)
+	}
+
+	var sce *cmap.ShardCountError
+	if err = cmap.NewSize(32).ApplyIncremental(bytes.NewReader(all.Bytes())); !errors.As(err, &sce) {
+		t.Fatalf("expected a *ShardCountError, got %v", err)
+	}
+}
+
+func TestIncrementalNil(t *testing.T) {
+	cm := cmap.NewSize(4)
+	cm.Set("a", 1)
+
+	var base, inc bytes.Buffer
+	gen := cm.Checkpoint()
+	if err := cm.SaveTo(&base); err != nil {
+		t.Fatal(err)
+	}
+	cm.Set("a", nil)
+	cm.Set(nil, 2)
+	if _, err := cm.SaveIncremental(&inc, gen); err != nil {
+		t.Fatal(err)
+	}
+
+	rcm, err := cmap.Restore(&base, &inc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(toMap(rcm), toMap(cm)) {
+		t.Fatalf("the restored map doesn't match: %v", toMap(rcm))
+	}
+}
+
+// incrementalFixture returns a map with keys of every basic kind, changed after the base snapshot by change.
+func incrementalFixture() (cm *cmap.CMap, change func()) {
+	cm = cmap.NewSize(32)
+	for i := 0; i < 500; i++ {
+		cm.Set(i, i)
+		cm.Set(int64(i)<<40, "int64")
+		cm.Set(float64(i)/4, "float64")
+		cm.Set(string(rune('a'+i%26))+string(rune('a'+i/26)), "string")
+	}
+	return cm, func() {
+		cm.Set(1, "one")
+		cm.Delete(int64(2) << 40)
+		cm.Set(0.5, "half")
+		cm.Set("new", true)
+	}
+}
+
+func TestIncrementalAcrossProcesses(t *testing.T) {
+	if dir := os.Getenv("CMAP_INCREMENTAL_DIR"); dir != "" {
+		// the child process writes the snapshots.
+		cm, change := incrementalFixture()
+		var base, inc bytes.Buffer
+		gen := cm.Checkpoint()
+		if err := cm.SaveTo(&base); err != nil {
+			t.Fatal(err)
+		}
+		change()
+		if _, err := cm.SaveIncremental(&inc, gen); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "base"), base.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "inc"), inc.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestIncrementalAcrossProcesses$")
+	cmd.Env = append(os.Environ(), "CMAP_INCREMENTAL_DIR="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child process: %v\n%s", err, out)
+	}
+
+	base, err := os.Open(filepath.Join(dir, "base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Close()
+	inc, err := os.Open(filepath.Join(dir, "inc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inc.Close()
+
+	rcm, err := cmap.Restore(base, inc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm, change := incrementalFixture()
+	change()
+	if !reflect.DeepEqual(toMap(rcm), toMap(cm)) {
+		t.Fatal("the restored map doesn't match")
+	}
+
+	// the keys hash differently in this process, so the shards of a live map can't be replaced.
+	if _, err = base.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inc.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	lcm, err := cmap.LoadFrom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lcm.ApplyIncremental(inc); err != cmap.ErrHashMismatch {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+}
+
+func TestIncrementalConcurrent(t *testing.T) {
+	cm := cmap.NewSize(16)
+	gen := cm.Checkpoint()
+
+	var base bytes.Buffer
+	if err := cm.SaveTo(&base); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cm.Set(w*1000+i%1000, i)
+				if i%3 == 0 {
+					cm.Delete(w*1000 + (i+500)%1000)
+				}
+			}
+		}(w)
+	}
+
+	var incs []io.Reader
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		var err error
+		if gen, err = cm.SaveIncremental(&buf, gen); err != nil {
+			t.Fatal(err)
+		}
+		incs = append(incs, &buf)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if _, err := cm.SaveIncremental(&buf, gen); err != nil {
+		t.Fatal(err)
+	}
+	incs = append(incs, &buf)
+
+	rcm, err := cmap.Restore(&base, incs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(toMap(rcm), toMap(cm)) {
+		t.Fatal("the restored map doesn't match")
+	}
+}
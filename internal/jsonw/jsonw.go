@@ -0,0 +1,123 @@
+// Package jsonw streams json to a writer for the MapWithJSON types of cmap, stringcmap and u64cmap.
+package jsonw
+
+import (
+	"bufio"
+	"io"
+)
+
+type writerWithBytes interface {
+	io.Writer
+	io.ByteWriter
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Encoder writes json to a writer, it stops at the first error and counts the bytes
+// actually accepted by the underlying writer.
+// Writers that don't implement io.ByteWriter are buffered.
+type Encoder struct {
+	w   writerWithBytes
+	cw  CountingWriter
+	err error
+}
+
+// NewEncoder returns an Encoder that writes to w, Close must be called to flush it.
+func NewEncoder(w io.Writer) *Encoder {
+	e := &Encoder{cw: CountingWriter{w: w}}
+	if _, ok := w.(writerWithBytes); ok {
+		e.w = &e.cw
+	} else {
+		e.w = bufio.NewWriter(&e.cw)
+	}
+	return e
+}
+
+// PutByte writes c, it returns false if it or a previous write failed.
+func (e *Encoder) PutByte(c byte) bool {
+	if e.err == nil {
+		e.err = e.w.WriteByte(c)
+	}
+	return e.err == nil
+}
+
+// Put writes p, it returns false if it or a previous write failed.
+func (e *Encoder) Put(p []byte) bool {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+	return e.err == nil
+}
+
+// Fail records err if there wasn't an error already and returns false, ex. for marshal errors.
+func (e *Encoder) Fail(err error) bool {
+	if e.err == nil {
+		e.err = err
+	}
+	return false
+}
+
+// Err returns the first error.
+func (e *Encoder) Err() error { return e.err }
+
+// Close flushes the buffered output and returns the number of bytes written and the first error.
+// It doesn't close the underlying writer.
+func (e *Encoder) Close() (int64, error) {
+	if e.err == nil {
+		if f, ok := e.w.(flusher); ok {
+			e.err = f.Flush()
+		}
+	}
+	return e.cw.n, e.err
+}
+
+// CountingWriter counts the bytes written to the underlying writer, errors are sticky.
+type CountingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// NewCountingWriter returns a CountingWriter that writes to w.
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+// Count returns the number of bytes accepted by the underlying writer.
+func (cw *CountingWriter) Count() int64 { return cw.n }
+
+// Write implements io.Writer, short writes return io.ErrShortWrite.
+func (cw *CountingWriter) Write(p []byte) (n int, err error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	cw.err = err
+	return
+}
+
+// WriteByte implements io.ByteWriter.
+func (cw *CountingWriter) WriteByte(c byte) error {
+	if bw, ok := cw.w.(io.ByteWriter); ok && cw.err == nil {
+		if cw.err = bw.WriteByte(c); cw.err == nil {
+			cw.n++
+		}
+		return cw.err
+	}
+	_, err := cw.Write([]byte{c})
+	return err
+}
+
+// Flush flushes the underlying writer if it's buffered.
+func (cw *CountingWriter) Flush() error {
+	if f, ok := cw.w.(flusher); ok && cw.err == nil {
+		cw.err = f.Flush()
+	}
+	return cw.err
+}
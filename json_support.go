@@ -0,0 +1,179 @@
+// +build !genx
+
+package cmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+
+	"github.com/OneOfOne/cmap/internal/jsonw"
+)
+
+// WithJSON returns a MapWithJSON with the specific unmarshal func.
+func (cm *CMap) WithJSON(fn func(raw json.RawMessage) (interface{}, error)) *MapWithJSON {
+	return &MapWithJSON{
+		CMap:             cm,
+		UnmarshalValueFn: fn,
+	}
+}
+
+// MapWithJSON is a CMap with json support.
+// Keys are encoded with MarshalKeyFn if it is set, otherwise they must be strings, integers
+// or implement encoding.TextMarshaler or fmt.Stringer.
+// Keys are decoded as strings unless UnmarshalKeyFn is set.
+// Usage:
+//
+//	var mwj MapWithJSON
+//	json.Unmarshal(`{"key":"value"}`, &mwj)
+type MapWithJSON struct {
+	*CMap
+	UnmarshalValueFn func(raw json.RawMessage) (interface{}, error)
+
+	MarshalKeyFn   func(key interface{}) (string, error)
+	UnmarshalKeyFn func(key string) (interface{}, error)
+}
+
+// UnsupportedKeyError is returned when a key can't be encoded as a json object key.
+type UnsupportedKeyError struct {
+	Key interface{}
+}
+
+func (e *UnsupportedKeyError) Error() string {
+	return fmt.Sprintf("cmap: unsupported json key type %T (%v)", e.Key, e.Key)
+}
+
+// WriteTo implements io.WriterTo, outputs the map as a json object.
+// It stops at the first marshal or write error and returns it with the number of bytes written to w.
+func (mwj *MapWithJSON) WriteTo(w io.Writer) (n int64, err error) {
+	e := jsonw.NewEncoder(w)
+	e.PutByte('{')
+
+	first := true
+	mwj.ForEach(func(key interface{}, val interface{}) bool {
+		if e.Err() != nil {
+			return false
+		}
+
+		ks, err := mwj.marshalKey(key)
+		if err != nil {
+			return e.Fail(err)
+		}
+		kj, err := json.Marshal(ks)
+		if err != nil {
+			return e.Fail(err)
+		}
+		vj, err := json.Marshal(val)
+		if err != nil {
+			return e.Fail(err)
+		}
+
+		if !first {
+			e.PutByte(',')
+		}
+		first = false
+
+		e.Put(kj)
+		e.PutByte(':')
+		return e.Put(vj)
+	})
+
+	e.PutByte('}')
+
+	return e.Close()
+}
+
+func (mwj *MapWithJSON) marshalKey(key interface{}) (string, error) {
+	if mwj.MarshalKeyFn != nil {
+		return mwj.MarshalKeyFn(key)
+	}
+
+	switch k := key.(type) {
+	case string:
+		return k, nil
+	case encoding.TextMarshaler:
+		b, err := k.MarshalText()
+		return string(b), err
+	case fmt.Stringer:
+		return k.String(), nil
+	}
+
+	switch rv := reflect.ValueOf(key); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+
+	return "", &UnsupportedKeyError{Key: key}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (mwj *MapWithJSON) UnmarshalJSON(p []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(p))
+
+	tok, err := dec.Token()
+	if err != nil || tok == nil { // null
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("cmap: expected a json object, got %v", tok)
+	}
+
+	if mwj.CMap == nil {
+		mwj.CMap = New()
+	}
+
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+
+		var key interface{} = tok.(string)
+		if mwj.UnmarshalKeyFn != nil {
+			if key, err = mwj.UnmarshalKeyFn(tok.(string)); err != nil {
+				return err
+			}
+		}
+
+		var val interface{}
+		if mwj.UnmarshalValueFn != nil {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err == nil {
+				val, err = mwj.UnmarshalValueFn(raw)
+			}
+		} else {
+			err = dec.Decode(&val)
+		}
+		if err != nil {
+			return err
+		}
+
+		mwj.Set(key, val)
+	}
+
+	_, err = dec.Token() // }
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (mwj *MapWithJSON) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := mwj.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (cm *CMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := cm.WithJSON(nil).WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
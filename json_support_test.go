@@ -0,0 +1,97 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+)
+
+type point struct{ x, y int }
+
+func TestJSON(t *testing.T) {
+	cm := cmap.New()
+	cm.Set("str", "a")
+	cm.Set(42, 1.5)
+	cm.Set(uint8(7), true)
+	cm.Set(net.IPv4(127, 0, 0, 1).To4().String(), nil)
+
+	j, err := json.Marshal(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mwj cmap.MapWithJSON
+	if err = json.Unmarshal(j, &mwj); err != nil {
+		t.Fatal(err)
+	}
+
+	if mwj.Len() != 4 || mwj.Get("str") != "a" || mwj.Get("42") != 1.5 || mwj.Get("7") != true || !mwj.Has("127.0.0.1") {
+		t.Fatalf("unexpected map: %s", j)
+	}
+
+	mwj = cmap.MapWithJSON{UnmarshalKeyFn: func(k string) (interface{}, error) { return strconv.Atoi(k) }}
+	if err = json.Unmarshal([]byte(`{"1":"a","2":"b"}`), &mwj); err != nil {
+		t.Fatal(err)
+	}
+	if mwj.Get(1) != "a" || mwj.Get(2) != "b" {
+		t.Fatalf("unexpected map: %v", mwj.Keys())
+	}
+
+	cm.Set(point{1, 2}, 3)
+	if _, err = json.Marshal(cm); err == nil {
+		t.Fatal("expected an *UnsupportedKeyError")
+	}
+	if _, err = cm.MarshalJSON(); err == nil {
+		t.Fatal("expected an *UnsupportedKeyError")
+	} else if ke, ok := err.(*cmap.UnsupportedKeyError); !ok || ke.Key != (point{1, 2}) {
+		t.Fatalf("expected an *UnsupportedKeyError, got %v", err)
+	}
+
+	mwj = cmap.MapWithJSON{
+		CMap: cm,
+		MarshalKeyFn: func(k interface{}) (string, error) {
+			if p, ok := k.(point); ok {
+				return strconv.Itoa(p.x) + "x" + strconv.Itoa(p.y), nil
+			}
+			return k.(string), nil
+		},
+	}
+	cm.Delete(42)
+	cm.Delete(uint8(7))
+	cm.Delete("127.0.0.1")
+	if j, err = json.Marshal(&mwj); err != nil || string(j) != `{"1x2":3,"str":"a"}` && string(j) != `{"str":"a","1x2":3}` {
+		t.Fatalf("unexpected output: %s %v", j, err)
+	}
+}
+
+type failWriter struct{ n int }
+
+func (fw *failWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.n {
+		n := fw.n
+		fw.n = 0
+		return n, net.ErrWriteToConnected
+	}
+	fw.n -= len(p)
+	return len(p), nil
+}
+
+func TestJSONWriteToError(t *testing.T) {
+	mwj := cmap.New().WithJSON(nil)
+	for i := 0; i < 1000; i++ {
+		mwj.Set(i, strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	if n, err := mwj.WriteTo(&buf); err != nil || n != int64(buf.Len()) {
+		t.Fatalf("n = %d, len = %d, err = %v", n, buf.Len(), err)
+	}
+
+	if n, err := mwj.WriteTo(&failWriter{n: 100}); err != net.ErrWriteToConnected || n != 100 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+}
@@ -2,13 +2,19 @@
 
 package cmap
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // LMap is a simple sync.RWMutex locked map.
 // Used by CMap internally for sharding.
 type LMap struct {
-	m map[KT]VT
-	l *sync.RWMutex
+	gen   uint64  // generation of the last modification, see incremental.go
+	clock *uint64 // shared by all the shards of a CMap
+	m     map[KT]VT
+	l     *sync.RWMutex
+	prof  atomic.Value // *lockProfile
 }
 
 // NewLMap returns a new LMap with the cap set to 0.
@@ -19,14 +25,15 @@ func NewLMap() *LMap {
 // NewLMapSize is the equivalent of `m := make(map[KT]VT, cap)`
 func NewLMapSize(cap int) *LMap {
 	return &LMap{
-		m: make(map[KT]VT, cap),
-		l: new(sync.RWMutex),
+		clock: newClock(),
+		m:     make(map[KT]VT, cap),
+		l:     new(sync.RWMutex),
 	}
 }
 
 // Set is the equivalent of `map[key] = val`.
 func (lm *LMap) Set(key KT, v VT) {
-	lm.l.Lock()
+	lm.lock()
 	lm.m[key] = v
 	lm.l.Unlock()
 }
@@ -34,7 +41,7 @@ func (lm *LMap) Set(key KT, v VT) {
 // SetIfNotExists will only assign val to key if it wasn't already set.
 // Use `Update` if you need more logic.
 func (lm *LMap) SetIfNotExists(key KT, val VT) (set bool) {
-	lm.l.Lock()
+	lm.lock()
 	if _, ok := lm.m[key]; !ok {
 		lm.m[key], set = val, true
 	}
@@ -44,7 +51,7 @@ func (lm *LMap) SetIfNotExists(key KT, val VT) (set bool) {
 
 // Get is the equivalent of `val := map[key]`.
 func (lm *LMap) Get(key KT) (v VT) {
-	lm.l.RLock()
+	lm.rlock()
 	v = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -52,7 +59,7 @@ func (lm *LMap) Get(key KT) (v VT) {
 
 // GetOK is the equivalent of `val, ok := map[key]`.
 func (lm *LMap) GetOK(key KT) (v VT, ok bool) {
-	lm.l.RLock()
+	lm.rlock()
 	v, ok = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -60,7 +67,7 @@ func (lm *LMap) GetOK(key KT) (v VT, ok bool) {
 
 // Has is the equivalent of `_, ok := map[key]`.
 func (lm *LMap) Has(key KT) (ok bool) {
-	lm.l.RLock()
+	lm.rlock()
 	_, ok = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -68,14 +75,14 @@ func (lm *LMap) Has(key KT) (ok bool) {
 
 // Delete is the equivalent of `delete(map, key)`.
 func (lm *LMap) Delete(key KT) {
-	lm.l.Lock()
+	lm.lock()
 	delete(lm.m, key)
 	lm.l.Unlock()
 }
 
 // DeleteAndGet is the equivalent of `oldVal := map[key]; delete(map, key)`.
 func (lm *LMap) DeleteAndGet(key KT) (v VT) {
-	lm.l.Lock()
+	lm.lock()
 	v = lm.m[key]
 	delete(lm.m, key)
 	lm.l.Unlock()
@@ -85,14 +92,14 @@ func (lm *LMap) DeleteAndGet(key KT) (v VT) {
 // Update calls `fn` with the key's old value (or nil) and assigns the returned value to the key.
 // The shard containing the key will be locked, it is NOT safe to call other cmap funcs inside `fn`.
 func (lm *LMap) Update(key KT, fn func(oldVal VT) (newVal VT)) {
-	lm.l.Lock()
+	lm.lock()
 	lm.m[key] = fn(lm.m[key])
 	lm.l.Unlock()
 }
 
 // Swap is the equivalent of `oldVal, map[key] = map[key], newVal`.
 func (lm *LMap) Swap(key KT, newV VT) (oldV VT) {
-	lm.l.Lock()
+	lm.lock()
 	oldV = lm.m[key]
 	lm.m[key] = newV
 	lm.l.Unlock()
@@ -103,14 +110,14 @@ func (lm *LMap) Swap(key KT, newV VT) (oldV VT) {
 // You can break early by returning an error .
 // It **is** safe to modify the map while using this iterator, however it uses more memory and is slightly slower.
 func (lm *LMap) ForEach(keys []KT, fn func(key KT, val VT) bool) bool {
-	lm.l.RLock()
+	lm.rlock()
 	for key := range lm.m {
 		keys = append(keys, key)
 	}
 	lm.l.RUnlock()
 
 	for _, key := range keys {
-		lm.l.RLock()
+		lm.rlock()
 		val, ok := lm.m[key]
 		lm.l.RUnlock()
 		if !ok {
@@ -128,7 +135,7 @@ func (lm *LMap) ForEach(keys []KT, fn func(key KT, val VT) bool) bool {
 // You can break early by returning false
 // It is **NOT* safe to modify the map while using this iterator.
 func (lm *LMap) ForEachLocked(fn func(key KT, val VT) bool) bool {
-	lm.l.RLock()
+	lm.rlock()
 	defer lm.l.RUnlock()
 
 	for key, val := range lm.m {
@@ -142,7 +149,7 @@ func (lm *LMap) ForEachLocked(fn func(key KT, val VT) bool) bool {
 
 // Len returns the length of the map.
 func (lm *LMap) Len() (ln int) {
-	lm.l.RLock()
+	lm.rlock()
 	ln = len(lm.m)
 	lm.l.RUnlock()
 	return
@@ -151,7 +158,7 @@ func (lm *LMap) Len() (ln int) {
 // Keys appends all the keys in the map to buf and returns buf.
 // buf may be nil.
 func (lm *LMap) Keys(buf []KT) []KT {
-	lm.l.RLock()
+	lm.rlock()
 	if cap(buf) == 0 {
 		buf = make([]KT, 0, len(lm.m))
 	}
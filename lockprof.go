@@ -0,0 +1,135 @@
+// +build genx
+
+package cmap
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/cmap/stats"
+)
+
+// EnableLockProfiling instruments the shard locks to record how long callers wait for them.
+// name identifies the map in the trace regions and pprof labels, if it's empty the map's address is used.
+// Waits are recorded in the histogram of every shard, see LMap.LockWaits, and contended waits run inside a
+// runtime/trace region named after the map and shard.
+// The runtime's mutex profile can't be split by map: it ignores goroutine labels and the shard locks don't set
+// any. For per-map and per-shard numbers, use LockWaits and the trace regions.
+func (cm *CMap) EnableLockProfiling(name string) {
+	if name == "" {
+		name = fmt.Sprintf("%p", cm)
+	}
+	for i, lm := range cm.shards {
+		lm.EnableLockProfiling(name, i)
+	}
+}
+
+// DisableLockProfiling removes the lock instrumentation from all the shards.
+func (cm *CMap) DisableLockProfiling() {
+	for _, lm := range cm.shards {
+		lm.DisableLockProfiling()
+	}
+}
+
+// LockWaits returns the merged lock wait histogram of all the shards.
+func (cm *CMap) LockWaits() (s stats.DurationSnapshot) {
+	for _, lm := range cm.shards {
+		ss := lm.LockWaits()
+		s.Merge(&ss)
+	}
+	return
+}
+
+// LabelContext returns ctx with the "cmap" and "shard" pprof labels of the shard that may hold key. The labels
+// only apply to the callers that use it, and only show in the CPU and goroutine profiles, not the mutex profile, ex.
+//
+//	pprof.Do(cm.LabelContext(ctx, key), pprof.Labels(), func(context.Context) { cm.Update(key, fn) })
+//
+// ctx is returned as is if lock profiling isn't enabled.
+func (cm *CMap) LabelContext(ctx context.Context, key KT) context.Context {
+	return cm.ShardForKey(key).LabelContext(ctx)
+}
+
+// EnableLockProfiling instruments the map's lock to record how long callers wait for it.
+// See CMap.EnableLockProfiling.
+func (lm *LMap) EnableLockProfiling(name string, shard int) {
+	ss := strconv.Itoa(shard)
+	lm.prof.Store(&lockProfile{
+		name:   name,
+		labels: pprof.Labels("cmap", name, "shard", ss),
+		region: "cmap:" + name + ":shard:" + ss,
+	})
+}
+
+// LabelContext returns ctx with the "cmap" and "shard" pprof labels of the map, see CMap.LabelContext.
+func (lm *LMap) LabelContext(ctx context.Context) context.Context {
+	if lp := lm.lockProfile(); lp != nil {
+		return pprof.WithLabels(ctx, lp.labels)
+	}
+	return ctx
+}
+
+// DisableLockProfiling removes the lock instrumentation.
+func (lm *LMap) DisableLockProfiling() {
+	lm.prof.Store((*lockProfile)(nil))
+}
+
+// LockWaits returns a snapshot of the lock wait histogram, it is empty if lock profiling isn't enabled.
+func (lm *LMap) LockWaits() stats.DurationSnapshot {
+	if lp := lm.lockProfile(); lp != nil {
+		return lp.waits.Snapshot()
+	}
+	return stats.DurationSnapshot{}
+}
+
+func (lm *LMap) lockProfile() *lockProfile {
+	lp, _ := lm.prof.Load().(*lockProfile)
+	return lp
+}
+
+// lock write-locks the map and marks it as dirty, see Generation.
+func (lm *LMap) lock() {
+	if lp := lm.lockProfile(); lp != nil {
+		lp.lock(lm.l, false)
+	} else {
+		lm.l.Lock()
+	}
+	lm.touch()
+}
+
+func (lm *LMap) rlock() {
+	if lp := lm.lockProfile(); lp != nil {
+		lp.lock(lm.l, true)
+		return
+	}
+	lm.l.RLock()
+}
+
+type lockProfile struct {
+	waits  stats.DurationHistogram
+	name   string
+	labels pprof.LabelSet
+	region string
+}
+
+func (lp *lockProfile) lock(l *sync.RWMutex, read bool) {
+	if read && l.TryRLock() || !read && l.TryLock() {
+		lp.waits.Observe(0)
+		return
+	}
+
+	start := time.Now()
+	r := trace.StartRegion(context.Background(), lp.region)
+	if read {
+		l.RLock()
+	} else {
+		l.Lock()
+	}
+	r.End()
+	lp.waits.Observe(time.Since(start))
+}
@@ -0,0 +1,108 @@
+package cmap_test
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestLockProfiling(t *testing.T) {
+	cm := cmap.NewSize(4)
+	cm.EnableLockProfiling("test")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				cm.Update(i%4, func(old interface{}) interface{} {
+					v, _ := old.(int)
+					return v + 1
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := cm.LockWaits()
+	if s.Count < 8000 {
+		t.Fatalf("expected at least 8000 lock acquisitions, got %d", s.Count)
+	}
+	t.Logf("%v", &s)
+
+	cm.DisableLockProfiling()
+	cm.Set(1, 1)
+	if s := cm.LockWaits(); s.Count != 0 {
+		t.Fatalf("expected an empty histogram, got %v", &s)
+	}
+}
+
+func TestLockProfilingKeepsLabels(t *testing.T) {
+	cm := cmap.NewSize(1)
+	cm.EnableLockProfiling("test")
+
+	var (
+		locked  = make(chan struct{})
+		release = make(chan struct{})
+		setDone = make(chan struct{})
+		exit    = make(chan struct{})
+	)
+	go cm.Update(1, func(interface{}) interface{} {
+		close(locked)
+		<-release
+		return 1
+	})
+	<-locked
+
+	go func() {
+		pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("req", "42")))
+		cm.Set(1, 2) // contended
+		close(setDone)
+		<-exit
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-setDone
+	defer close(exit)
+
+	if s := cm.LockWaits(); s.Sum < 10*time.Millisecond {
+		t.Fatalf("expected a contended wait, got %v", &s)
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"req":"42"`) {
+		t.Fatal("the caller's pprof labels were lost")
+	}
+}
+
+func TestLockProfilingLabelContext(t *testing.T) {
+	cm := cmap.NewSize(8)
+	ctx := context.Background()
+	if cm.LabelContext(ctx, 1) != ctx {
+		t.Fatal("expected the context as is without lock profiling")
+	}
+
+	cm.EnableLockProfiling("test")
+	for i := 0; i < 8; i++ {
+		lctx := cm.LabelContext(ctx, i)
+		shard := strconv.FormatUint(cm.Hash(i)&7, 10)
+		if v, _ := pprof.Label(lctx, "cmap"); v != "test" {
+			t.Fatalf("%d: unexpected cmap label %q", i, v)
+		}
+		if v, _ := pprof.Label(lctx, "shard"); v != shard {
+			t.Fatalf("%d: expected shard label %s, got %q", i, shard, v)
+		}
+	}
+}
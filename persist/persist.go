@@ -0,0 +1,347 @@
+// Package persist makes a stringcmap.CMap durable using an append-only write-ahead log and periodic snapshots.
+//
+// Every mutation of the map, including the ones done directly on DB.Map(), is appended to the log while the
+// key's shard is locked, so replaying the log always reproduces the order the mutations were applied in.
+// On Open, the latest snapshot is loaded and the log segments written after it are replayed,
+// a torn record at the end of the last segment (from a crash mid-write) is truncated.
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/cmap/snapshot"
+	"github.com/OneOfOne/cmap/stringcmap"
+)
+
+// SyncPolicy controls when the log is synced to disk.
+type SyncPolicy uint8
+
+const (
+	// SyncAlways syncs the log before Set, Delete and Update return,
+	// concurrent writers are grouped in the same sync.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log every Options.SyncInterval, writes done since the last sync may be lost.
+	SyncInterval
+	// SyncNever leaves syncing to the operating system, the log is only synced on rotation and Close.
+	SyncNever
+)
+
+// The defaults used for zero values in Options.
+const (
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = 100 * time.Millisecond
+)
+
+// Options are the options used by Open, the zero value is valid.
+type Options struct {
+	// Shards is the number of shards of a new map, ignored if a snapshot exists.
+	Shards int
+
+	// ValueCodec encodes the values in the log and snapshots, the default is snapshot.GobCodec.
+	// Every log record is encoded on its own, so with gob each one also holds the type description of its value,
+	// when all the values are strings snapshot.StringCodec keeps the log smaller.
+	ValueCodec snapshot.Codec
+
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+
+	// SegmentSize is the size after which a new log segment is started.
+	SegmentSize int64
+
+	// CompactInterval is how often a snapshot is written and the older segments removed,
+	// 0 disables automatic compaction.
+	CompactInterval time.Duration
+}
+
+// DB is a stringcmap.CMap backed by a write-ahead log.
+type DB struct {
+	cm    *stringcmap.CMap
+	dir   string
+	opts  Options
+	wal   *wal
+	hooks []func()
+
+	compactMux sync.Mutex
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Open recovers the map stored in dir, creating dir if it doesn't exist.
+func Open(dir string, opts *Options) (*DB, error) {
+	db := &DB{dir: dir, done: make(chan struct{})}
+	if opts != nil {
+		db.opts = *opts
+	}
+	if db.opts.ValueCodec == nil {
+		db.opts.ValueCodec = snapshot.GobCodec{}
+	}
+	if db.opts.SegmentSize < 1 {
+		db.opts.SegmentSize = DefaultSegmentSize
+	}
+	if db.opts.SyncInterval <= 0 {
+		db.opts.SyncInterval = DefaultSyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	nextSeg, err := db.recover()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.wal, err = openWAL(dir, nextSeg, &db.opts); err != nil {
+		return nil, err
+	}
+
+	db.hooks = append(db.hooks,
+		db.cm.OnSet(db.logSet, stringcmap.HookLocked),
+		db.cm.OnDelete(db.logDelete, stringcmap.HookLocked),
+		db.cm.OnEvict(db.logDelete, stringcmap.HookLocked),
+	)
+
+	if db.opts.CompactInterval > 0 {
+		db.wg.Add(1)
+		go db.compactLoop()
+	}
+
+	return db, nil
+}
+
+// Map returns the underlying map, all its mutations are logged, however only the funcs of DB wait for
+// the log to be synced.
+func (db *DB) Map() *stringcmap.CMap { return db.cm }
+
+// Get is the equivalent of `val := map[key]`.
+func (db *DB) Get(key string) interface{} { return db.cm.Get(key) }
+
+// Set is the equivalent of `map[key] = val`, it returns after the change is logged according to the sync policy.
+func (db *DB) Set(key string, val interface{}) error {
+	db.cm.Set(key, val)
+	return db.commit()
+}
+
+// Delete is the equivalent of `delete(map, key)`, it returns after the change is logged according to the sync policy.
+func (db *DB) Delete(key string) error {
+	db.cm.Delete(key)
+	return db.commit()
+}
+
+// Update is the equivalent of stringcmap.CMap.Update, it returns after the change is logged according to the
+// sync policy.
+func (db *DB) Update(key string, fn func(oldVal interface{}) (newVal interface{})) error {
+	db.cm.Update(key, fn)
+	return db.commit()
+}
+
+// Sync writes and syncs all the logged changes.
+func (db *DB) Sync() error {
+	n, err := db.wal.state()
+	if err != nil {
+		return err
+	}
+	if err = db.wal.flush(true); err != nil {
+		return err
+	}
+	return db.wal.wait(n)
+}
+
+// Compact writes a snapshot of the map and removes the log segments and snapshots it replaces.
+// Writers are never blocked on more than one shard while the snapshot is written.
+func (db *DB) Compact() error {
+	db.compactMux.Lock()
+	defer db.compactMux.Unlock()
+
+	// every change logged before the rotation is already applied to the map, so the snapshot includes it.
+	// changes applied while the snapshot is written may be in both the snapshot and the new segment,
+	// replaying them is harmless since every record holds the full value.
+	segID, err := db.wal.rotate()
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(db.dir, snapshotName(segID)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = db.cm.SaveToWith(f, snapshot.StringCodec{}, db.opts.ValueCodec)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(db.dir, snapshotName(segID)))
+	}
+	if err == nil {
+		err = syncDir(db.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return db.removeBefore(segID)
+}
+
+// Close syncs the log and stops the background goroutines, the map remains usable but changes are no longer logged.
+func (db *DB) Close() error {
+	for _, rm := range db.hooks {
+		rm()
+	}
+	close(db.done)
+	db.wg.Wait()
+	return db.wal.close()
+}
+
+func (db *DB) commit() error {
+	n, err := db.wal.state()
+	if err != nil || db.opts.Sync != SyncAlways {
+		return err
+	}
+	return db.wal.wait(n)
+}
+
+func (db *DB) logSet(ev stringcmap.Event) {
+	rec, err := encodeSet(db.opts.ValueCodec, ev.Key, ev.NewValue)
+	if err != nil {
+		db.wal.fail(err)
+		return
+	}
+	db.wal.append(rec)
+}
+
+func (db *DB) logDelete(ev stringcmap.Event) {
+	db.wal.append(encodeDelete(ev.Key))
+}
+
+func (db *DB) compactLoop() {
+	defer db.wg.Done()
+	t := time.NewTicker(db.opts.CompactInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := db.Compact(); err != nil {
+				db.wal.fail(err)
+				return
+			}
+		case <-db.done:
+			return
+		}
+	}
+}
+
+func snapshotName(id uint64) string { return fmt.Sprintf("snap-%016x.cmap", id) }
+
+func parseName(name, prefix, suffix string, id *uint64) bool {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	v, err := strconv.ParseUint(name[len(prefix):len(name)-len(suffix)], 16, 64)
+	*id = v
+	return err == nil
+}
+
+// recover loads the latest snapshot, replays the segments after it and returns the id of the next segment.
+func (db *DB) recover() (uint64, error) {
+	snaps, segs, err := db.list()
+	if err != nil {
+		return 0, err
+	}
+
+	var snapID uint64
+	if len(snaps) > 0 {
+		snapID = snaps[len(snaps)-1]
+		f, err := os.Open(filepath.Join(db.dir, snapshotName(snapID)))
+		if err != nil {
+			return 0, err
+		}
+		db.cm, err = stringcmap.LoadFrom(f)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		db.cm = stringcmap.NewSize(db.opts.Shards)
+	}
+
+	apply := func(op byte, key string, val interface{}) {
+		if op == opSet {
+			db.cm.Set(key, val)
+		} else {
+			db.cm.Delete(key)
+		}
+	}
+
+	next := snapID + 1
+	for i, id := range segs {
+		if id < snapID {
+			continue
+		}
+		if err = replaySegment(filepath.Join(db.dir, segmentName(id)), i == len(segs)-1, apply); err != nil {
+			return 0, err
+		}
+		next = id + 1
+	}
+
+	return next, db.removeBefore(snapID)
+}
+
+// list returns the sorted ids of the snapshots and segments in the directory.
+func (db *DB) list() (snaps, segs []uint64, err error) {
+	ents, err := os.ReadDir(db.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, e := range ents {
+		var id uint64
+		switch name := e.Name(); {
+		case strings.HasSuffix(name, ".tmp"):
+			os.Remove(filepath.Join(db.dir, name))
+		case parseName(name, "snap-", ".cmap", &id):
+			snaps = append(snaps, id)
+		case parseName(name, "wal-", ".log", &id):
+			segs = append(segs, id)
+		}
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i] < snaps[j] })
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return
+}
+
+// removeBefore removes the segments and snapshots replaced by snapshot id.
+func (db *DB) removeBefore(id uint64) error {
+	snaps, segs, err := db.list()
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		if s < id {
+			if err = os.Remove(filepath.Join(db.dir, snapshotName(s))); err != nil {
+				return err
+			}
+		}
+	}
+	for _, s := range segs {
+		if s < id {
+			if err = os.Remove(filepath.Join(db.dir, segmentName(s))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
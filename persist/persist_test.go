@@ -0,0 +1,206 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func reopen(t *testing.T, db *DB, dir string, opts *Options) *DB {
+	t.Helper()
+	if db != nil {
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	db := reopen(t, nil, dir, &Options{Shards: 16})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := db.Set(strconv.Itoa(g*100+i), i); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := db.Delete("0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update("1", func(old interface{}) interface{} { return old.(int) + 1000 }); err != nil {
+		t.Fatal(err)
+	}
+	db.Map().Swap("2", "swapped")
+
+	db = reopen(t, db, dir, nil)
+	defer db.Close()
+
+	if n := db.Map().Len(); n != 399 {
+		t.Fatalf("expected 399 keys, got %d", n)
+	}
+	if db.Map().Has("0") || db.Get("1") != 1001 || db.Get("2") != "swapped" || db.Get("399") != 99 {
+		t.Fatalf("unexpected values: %v %v %v", db.Get("1"), db.Get("2"), db.Get("399"))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	db := reopen(t, nil, dir, &Options{Sync: SyncNever, SegmentSize: 256})
+
+	for i := 0; i < 100; i++ {
+		db.Set(strconv.Itoa(i), i)
+	}
+	db.Set("nil", nil)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 150; i++ {
+		db.Set(strconv.Itoa(i), i)
+	}
+	db.Delete("5")
+
+	snaps, segs, err := db.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || segs[0] != snaps[0] {
+		t.Fatalf("expected 1 snapshot and the segments after it, got %v %v", snaps, segs)
+	}
+
+	db = reopen(t, db, dir, nil)
+	defer db.Close()
+	if n := db.Map().Len(); n != 150 || db.Map().Has("5") || db.Get("149") != 149 || !db.Map().Has("nil") {
+		t.Fatalf("unexpected map after recovery: %d keys", n)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	db := reopen(t, nil, dir, nil)
+	db.Set("a", 1)
+	db.Set("b", 2)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seg := filepath.Join(dir, segmentName(1))
+	st, err := os.Stat(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(seg, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2, 3})
+	f.Close()
+
+	db = reopen(t, nil, dir, nil)
+	if db.Get("a") != 1 || db.Get("b") != 2 {
+		t.Fatalf("unexpected values: %v %v", db.Get("a"), db.Get("b"))
+	}
+	if st2, _ := os.Stat(seg); st2.Size() != st.Size() {
+		t.Fatalf("expected the torn record to be truncated: %d != %d", st2.Size(), st.Size())
+	}
+	db.Set("c", 3)
+	db = reopen(t, db, dir, nil)
+	defer db.Close()
+	if db.Map().Len() != 3 {
+		t.Fatalf("expected 3 keys, got %d", db.Map().Len())
+	}
+
+	// corruption in a segment that isn't the last one is an error
+	p, _ := os.ReadFile(seg)
+	p[len(p)-1] ^= 0xff
+	os.WriteFile(seg, p, 0644)
+	if _, err := Open(dir, nil); err == nil {
+		t.Fatal("expected a *CorruptError")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Fatalf("expected a *CorruptError, got %v", err)
+	}
+}
+
+func TestCorruptLastSegment(t *testing.T) {
+	for name, corrupt := range map[string]func(p []byte) []byte{
+		"first record": func(p []byte) []byte { p[walHdrSize+recHdrSize+2] ^= 0xff; return p },
+		"length":       func(p []byte) []byte { p[walHdrSize+3] = 1; return p },
+		"huge length":  func(p []byte) []byte { p[walHdrSize] = 0x7f; return p },
+		"zero length":  func(p []byte) []byte { copy(p[walHdrSize:], make([]byte, recHdrSize)); return p },
+	} {
+		dir := t.TempDir()
+		db := reopen(t, nil, dir, nil)
+		db.Set("a", 1)
+		db.Set("b", 2)
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		seg := filepath.Join(dir, segmentName(1))
+		p, err := os.ReadFile(seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(seg, corrupt(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Open(dir, nil); err == nil {
+			t.Fatalf("%s: expected a *CorruptError", name)
+		} else if _, ok := err.(*CorruptError); !ok {
+			t.Fatalf("%s: expected a *CorruptError, got %v", name, err)
+		}
+		if st, _ := os.Stat(seg); st.Size() != int64(len(p)) {
+			t.Fatalf("%s: the segment was truncated to %d bytes", name, st.Size())
+		}
+	}
+}
+
+func TestZeroedTail(t *testing.T) {
+	dir := t.TempDir()
+	db := reopen(t, nil, dir, nil)
+	db.Set("a", 1)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	seg := filepath.Join(dir, segmentName(1))
+	st, err := os.Stat(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// some filesystems extend the file before the data is written
+	f, err := os.OpenFile(seg, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 64))
+	f.Close()
+
+	db = reopen(t, nil, dir, nil)
+	defer db.Close()
+	if db.Get("a") != 1 {
+		t.Fatalf("unexpected value: %v", db.Get("a"))
+	}
+	if st2, _ := os.Stat(seg); st2.Size() != st.Size() {
+		t.Fatalf("expected the zeroes to be truncated: %d != %d", st2.Size(), st.Size())
+	}
+}
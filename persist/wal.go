@@ -0,0 +1,440 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+const (
+	walMagic   = "CWAL"
+	walVersion = 1
+	walHdrSize = 4 + 1 + 2
+
+	recHdrSize = 4 + 4 // length, crc32
+
+	opSet    byte = 1
+	opDelete byte = 2
+)
+
+// ErrClosed is returned by all the funcs of a closed DB.
+var ErrClosed = errors.New("persist: closed")
+
+// CorruptError is returned when a record in the middle of the log is corrupted,
+// a torn record at the very end of the last segment is truncated instead.
+type CorruptError struct {
+	Segment string
+	Offset  int64
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("persist: corrupted record in %s at offset %d", e.Segment, e.Offset)
+}
+
+// wal is an append-only log split in segments, records are buffered in memory by append and written by flushLoop,
+// which groups all the records appended while the previous batch was written and synced.
+type wal struct {
+	dir    string
+	vc     snapshot.Codec
+	policy SyncPolicy
+	maxSeg int64
+
+	mux      sync.Mutex
+	cond     *sync.Cond
+	buf      []byte
+	spare    []byte
+	appended uint64 // number of records appended
+	written  uint64 // number of records written to the segment
+	synced   uint64 // number of records synced to disk
+	err      error  // sticky, the wal is unusable after the first error
+	closed   bool
+
+	flushMux sync.Mutex // serializes flush and rotate
+	seg      *os.File
+	segID    uint64
+	segSize  int64
+
+	kick chan struct{}
+	done chan struct{}
+}
+
+func segmentName(id uint64) string { return fmt.Sprintf("wal-%016x.log", id) }
+
+func openWAL(dir string, segID uint64, opts *Options) (*wal, error) {
+	w := &wal{
+		dir:    dir,
+		vc:     opts.ValueCodec,
+		policy: opts.Sync,
+		maxSeg: opts.SegmentSize,
+		kick:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mux)
+
+	if err := w.openSegment(segID); err != nil {
+		return nil, err
+	}
+
+	go w.flushLoop(opts.SyncInterval)
+	return w, nil
+}
+
+func (w *wal) openSegment(id uint64) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(id)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var hdr [walHdrSize]byte
+	copy(hdr[:], walMagic)
+	hdr[4] = walVersion
+	binary.BigEndian.PutUint16(hdr[5:], w.vc.ID())
+	if _, err = f.Write(hdr[:]); err != nil {
+		f.Close()
+		return err
+	}
+	if err = syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.seg, w.segID, w.segSize = f, id, walHdrSize
+	return nil
+}
+
+// append adds an encoded record to the pending batch, it is called while the key's shard is locked,
+// so the order of the records of a key always matches the order they were applied in.
+func (w *wal) append(rec []byte) {
+	w.mux.Lock()
+	w.buf = append(w.buf, rec...)
+	w.appended++
+	w.mux.Unlock()
+
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until the first n records are synced to disk.
+func (w *wal) wait(n uint64) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	for w.synced < n && w.err == nil {
+		w.cond.Wait()
+	}
+	return w.err
+}
+
+func (w *wal) state() (appended uint64, err error) {
+	w.mux.Lock()
+	appended, err = w.appended, w.err
+	w.mux.Unlock()
+	return
+}
+
+func (w *wal) fail(err error) {
+	w.mux.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.cond.Broadcast()
+	w.mux.Unlock()
+}
+
+func (w *wal) flushLoop(interval time.Duration) {
+	var tick <-chan time.Time
+	if w.policy == SyncInterval {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-w.kick:
+			_ = w.flush(w.policy == SyncAlways)
+		case <-tick:
+			_ = w.flush(true)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// flush writes the pending records to the current segment and optionally syncs it,
+// the segment is rotated when it grows over the maximum size.
+func (w *wal) flush(sync bool) error {
+	w.flushMux.Lock()
+	defer w.flushMux.Unlock()
+
+	w.mux.Lock()
+	if w.err != nil {
+		w.mux.Unlock()
+		return w.err
+	}
+	buf, n := w.buf, w.appended
+	w.buf, w.spare = w.spare[:0], nil
+	w.mux.Unlock()
+
+	var err error
+	if len(buf) > 0 {
+		_, err = w.seg.Write(buf)
+	}
+	if err == nil && sync {
+		err = w.seg.Sync()
+	}
+
+	w.mux.Lock()
+	w.spare = buf[:0]
+	w.segSize += int64(len(buf))
+	w.written = n
+	if sync {
+		w.synced = n
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.cond.Broadcast()
+	rotate := err == nil && w.segSize >= w.maxSeg
+	w.mux.Unlock()
+
+	if rotate {
+		err = w.rotateLocked()
+	}
+	return err
+}
+
+// rotate flushes and syncs the current segment and starts a new one, it returns the id of the new segment.
+func (w *wal) rotate() (uint64, error) {
+	if err := w.flush(true); err != nil {
+		return 0, err
+	}
+
+	w.flushMux.Lock()
+	defer w.flushMux.Unlock()
+	if err := w.rotateLocked(); err != nil {
+		return 0, err
+	}
+	return w.segID, nil
+}
+
+// rotateLocked must be called with flushMux held, records appended after the last flush go to the new segment.
+func (w *wal) rotateLocked() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.seg.Sync(); err != nil {
+		w.err = err
+		return err
+	}
+	w.synced = w.written
+	w.cond.Broadcast()
+
+	if err := w.seg.Close(); err != nil {
+		w.err = err
+		return err
+	}
+
+	if err := w.openSegment(w.segID + 1); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	w.mux.Unlock()
+
+	close(w.done)
+	err := w.flush(true)
+	if cerr := w.seg.Close(); err == nil {
+		err = cerr
+	}
+
+	w.fail(ErrClosed)
+	return err
+}
+
+// encodeSet encodes a set record, every record has its own value encoder so it can be decoded on its own,
+// with snapshot.GobCodec that means every record carries the gob type description of its value, which is
+// usually larger than the value itself.
+func encodeSet(vc snapshot.Codec, key string, val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recHdrSize))
+	buf.WriteByte(opSet)
+	if err := (snapshot.StringCodec{}).NewEncoder(&buf).Encode(key); err != nil {
+		return nil, err
+	}
+	if err := vc.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, err
+	}
+	return sealRecord(buf.Bytes()), nil
+}
+
+func encodeDelete(key string) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recHdrSize))
+	buf.WriteByte(opDelete)
+	_ = (snapshot.StringCodec{}).NewEncoder(&buf).Encode(key)
+	return sealRecord(buf.Bytes())
+}
+
+func sealRecord(p []byte) []byte {
+	binary.BigEndian.PutUint32(p, uint32(len(p)-recHdrSize))
+	binary.BigEndian.PutUint32(p[4:], crc32.ChecksumIEEE(p[recHdrSize:]))
+	return p
+}
+
+// replaySegment applies all the records of a segment, if truncate is true a torn tail, a partially written
+// last record or zeroes past it, is removed from the file, any other damage returns a *CorruptError.
+func replaySegment(path string, truncate bool, apply func(op byte, key string, val interface{})) error {
+	p, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if len(p) < walHdrSize || string(p[:4]) != walMagic || p[4] != walVersion {
+		if truncate && len(p) < walHdrSize {
+			if err = os.Remove(path); err != nil {
+				return err
+			}
+			return syncDir(filepath.Dir(path))
+		}
+		return &CorruptError{Segment: filepath.Base(path)}
+	}
+
+	vc, ok := snapshot.Lookup(binary.BigEndian.Uint16(p[5:]))
+	if !ok {
+		return &snapshot.UnknownCodecError{ID: binary.BigEndian.Uint16(p[5:])}
+	}
+
+	off := walHdrSize
+	for off < len(p) {
+		op, key, val, n, err := decodeRecord(p[off:], vc)
+		if err != nil {
+			if truncate && ((err == errTorn && !intactAfter(p[off:], vc)) || allZero(p[off:])) {
+				return truncateSegment(path, int64(off))
+			}
+			return &CorruptError{Segment: filepath.Base(path), Offset: int64(off)}
+		}
+		apply(op, key, val)
+		off += n
+	}
+
+	return nil
+}
+
+// truncateSegment removes everything after off and syncs the file and its directory.
+func truncateSegment(path string, off int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(off); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// intactAfter reports whether there is an intact record anywhere after the damaged record at the start of p.
+// A crash only leaves a partial record at the very end of the segment, so a record that looks torn but is
+// followed by intact ones has a corrupted length instead.
+func intactAfter(p []byte, vc snapshot.Codec) bool {
+	for i := 1; i+recHdrSize < len(p); i++ {
+		if _, _, _, _, err := decodeRecord(p[i:], vc); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func allZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// errTorn is returned for a record that runs to the end of the segment and is incomplete or fails
+	// its checksum, which is what a crash in the middle of a write leaves behind, or what a corrupted length
+	// looks like, see intactAfter.
+	errTorn = errors.New("persist: torn record")
+	// errBadRecord is returned for a damaged record that is followed by more data.
+	errBadRecord = errors.New("persist: corrupted record")
+)
+
+func decodeRecord(p []byte, vc snapshot.Codec) (op byte, key string, val interface{}, n int, err error) {
+	if len(p) < recHdrSize {
+		return 0, "", nil, 0, errTorn
+	}
+
+	ln := int(binary.BigEndian.Uint32(p))
+	if ln < 1 {
+		return 0, "", nil, 0, errBadRecord
+	}
+	if len(p)-recHdrSize < ln {
+		return 0, "", nil, 0, errTorn
+	}
+
+	body := p[recHdrSize : recHdrSize+ln]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(p[4:]) {
+		if recHdrSize+ln == len(p) {
+			return 0, "", nil, 0, errTorn
+		}
+		return 0, "", nil, 0, errBadRecord
+	}
+
+	br := bytes.NewReader(body[1:])
+	k, err := (snapshot.StringCodec{}).NewDecoder(br).Decode()
+	if err != nil {
+		return 0, "", nil, 0, err
+	}
+
+	switch op = body[0]; op {
+	case opSet:
+		if val, err = vc.NewDecoder(br).Decode(); err != nil {
+			return 0, "", nil, 0, err
+		}
+	case opDelete:
+	default:
+		return 0, "", nil, 0, errBadRecord
+	}
+
+	return op, k.(string), val, recHdrSize + ln, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
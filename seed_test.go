@@ -0,0 +1,47 @@
+package cmap_test
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestSeed(t *testing.T) {
+	a, b := cmap.New(), cmap.New()
+	if a.Seed() == b.Seed() {
+		t.Fatal("expected random seeds")
+	}
+
+	x, y := cmap.NewSizeSeed(16, 42), cmap.NewSizeSeed(16, 42)
+	for i := 0; i < 100; i++ {
+		k := "key-" + strconv.Itoa(i)
+		x.Set(k, i)
+		y.Set(k, i)
+	}
+	if !reflect.DeepEqual(x.ShardDistribution(), y.ShardDistribution()) {
+		t.Fatal("maps with the same seed should have the same distribution")
+	}
+
+	var buf bytes.Buffer
+	if err := x.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lcm, err := cmap.LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lcm.Seed() != 42 || !reflect.DeepEqual(lcm.ShardDistribution(), x.ShardDistribution()) {
+		t.Fatalf("LoadFrom didn't keep the seed: %d", lcm.Seed())
+	}
+
+	buf.Reset()
+	if _, err = x.SaveIncremental(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = cmap.NewSizeSeed(16, 43).ApplyIncremental(&buf); err != cmap.ErrSeedMismatch {
+		t.Fatalf("expected ErrSeedMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+// +build genx
+
+package cmap
+
+import (
+	"io"
+	"sync"
+
+	"github.com/OneOfOne/cmap/hashers"
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+// SaveTo writes a binary snapshot of the map to w using the default codecs for the key and value types,
+// see SaveToWith.
+func (cm *CMap) SaveTo(w io.Writer) error {
+	var (
+		k KT
+		v VT
+	)
+	return cm.SaveToWith(w, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v))
+}
+
+// SaveToWith writes a binary snapshot of the map to w using the specific codecs.
+// Shards are encoded one at a time while read-locked, so writers are never blocked on more than one shard
+// or on w, however the snapshot isn't an atomic copy of a map that is being modified.
+func (cm *CMap) SaveToWith(w io.Writer, kc, vc snapshot.Codec) error {
+	sw, err := snapshot.NewWriterSeed(w, kc, vc, len(cm.shards), cm.Len(), cm.seed)
+	if err != nil {
+		return err
+	}
+
+	for i, lm := range cm.shards {
+		if err = sw.WriteShard(i, lm.snapshot); err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// LoadFrom reads a snapshot written by SaveTo or SaveToWith and returns a new CMap with the same number of shards
+// and hash seed, version 1 snapshots get a random seed.
+// The codecs are looked up by the ids in the snapshot header.
+// Keys are put in the shards they hash to, so snapshots written by another process or with another hasher load too.
+func LoadFrom(r io.Reader) (*CMap, error) {
+	shards, seed, err := readFull(r)
+	if err != nil {
+		return nil, err
+	}
+	return fromShards(shards, seed), nil
+}
+
+// readFull reads a snapshot that has the sections of all the shards in order, like SaveTo writes them, and returns
+// the entries of every shard and the hash seed.
+// The shards are allocated as their sections are read, so the shard count in the header can't allocate more than
+// the input justifies.
+func readFull(r io.Reader) (shards []map[KT]VT, seed uint64, err error) {
+	sr, err := snapshot.NewReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = readShards(sr, func(idx int, m map[KT]VT) error {
+		if idx != len(shards) {
+			return snapshot.ErrCorrupt
+		}
+		shards = append(shards, m)
+		return nil
+	})
+	if err == nil && len(shards) != int(sr.Shards) {
+		err = snapshot.ErrCorrupt
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if seed = sr.Seed; sr.Version < 2 {
+		seed = hashers.NewSeed()
+	}
+	return shards, seed, nil
+}
+
+// readShards decodes the shard sections of a snapshot one at a time and calls fn with every shard.
+func readShards(sr *snapshot.Reader, fn func(idx int, m map[KT]VT) error) error {
+	for {
+		m := make(map[KT]VT)
+		idx, err := sr.ReadShard(func(k, v interface{}) error {
+			key, val, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			m[key] = val
+			return nil
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(idx, m); err != nil {
+			return err
+		}
+	}
+}
+
+// fromShards returns a CMap made of the shards of a snapshot, the keys that hash to another shard are moved to it,
+// ex. the snapshot was written by another process and the hasher is built on hash/maphash.
+func fromShards(ms []map[KT]VT, seed uint64) *CMap {
+	var (
+		shards = make([]*LMap, len(ms))
+		mask   = uint64(len(ms) - 1)
+		moved  []KV
+	)
+	for i, m := range ms {
+		for k, v := range m {
+			if hasher(k, seed)&mask != uint64(i) {
+				moved = append(moved, KV{k, v})
+				delete(m, k)
+			}
+		}
+		shards[i] = &LMap{m: m, l: new(sync.RWMutex)}
+	}
+
+	for _, kv := range moved {
+		shards[hasher(kv.Key, seed)&mask].m[kv.Key] = kv.Value
+	}
+	return newCMap(shards, seed)
+}
+
+// decodeEntry converts a key and value read from a snapshot to the types of the map.
+// nil is accepted, it is how a nil interface is decoded and the codecs never decode it for other types.
+func decodeEntry(k, v interface{}) (key KT, val VT, err error) {
+	var ok bool
+	if key, ok = k.(KT); !ok && k != nil {
+		return key, val, &snapshot.TypeError{Codec: "key", Value: k, Decode: true}
+	}
+	if val, ok = v.(VT); !ok && v != nil {
+		return key, val, &snapshot.TypeError{Codec: "value", Value: v, Decode: true}
+	}
+	return key, val, nil
+}
+
+// snapshot encodes all the entries of the map while it is read-locked.
+func (lm *LMap) snapshot(add func(key, val interface{}) error) error {
+	lm.rlock()
+	defer lm.l.RUnlock()
+	return lm.snapshotLocked(add)
+}
+
+// snapshotLocked encodes all the entries of the map, the caller must hold the lock.
+func (lm *LMap) snapshotLocked(add func(key, val interface{}) error) error {
+	for k, v := range lm.m {
+		if err := add(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
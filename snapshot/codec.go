@@ -0,0 +1,201 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// The ids of the built-in codecs, custom codecs must use ids >= 128.
+const (
+	StringCodecID uint16 = 1
+	Uint64CodecID uint16 = 2
+	GobCodecID    uint16 = 3
+)
+
+// Codec creates encoders and decoders for keys or values.
+type Codec interface {
+	// ID identifies the codec in the snapshot header, it must never change.
+	ID() uint16
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r ByteReader) Decoder
+}
+
+// Encoder encodes a single key or value.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder decodes a single key or value.
+type Decoder interface {
+	Decode() (interface{}, error)
+}
+
+// ByteReader is the reader passed to Codec.NewDecoder.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+var (
+	codecsMux sync.RWMutex
+	codecs    = map[uint16]Codec{}
+)
+
+func init() {
+	Register(StringCodec{})
+	Register(Uint64Codec{})
+	Register(GobCodec{})
+}
+
+// Register makes a codec available to the snapshot readers, it panics if the id is already registered.
+func Register(c Codec) {
+	codecsMux.Lock()
+	defer codecsMux.Unlock()
+	if _, ok := codecs[c.ID()]; ok {
+		panic(fmt.Sprintf("snapshot: codec id %d is already registered", c.ID()))
+	}
+	codecs[c.ID()] = c
+}
+
+// Lookup returns the codec registered with id.
+func Lookup(id uint16) (c Codec, ok bool) {
+	codecsMux.RLock()
+	c, ok = codecs[id]
+	codecsMux.RUnlock()
+	return
+}
+
+// DefaultCodec returns the built-in codec for the type of v, GobCodec is used for anything that isn't
+// a string or an uint64.
+func DefaultCodec(v interface{}) Codec {
+	switch v.(type) {
+	case string:
+		return StringCodec{}
+	case uint64:
+		return Uint64Codec{}
+	default:
+		return GobCodec{}
+	}
+}
+
+// StringCodec encodes strings as an uvarint length followed by the bytes of the string.
+type StringCodec struct{}
+
+// ID implements Codec.
+func (StringCodec) ID() uint16 { return StringCodecID }
+
+// NewEncoder implements Codec.
+func (StringCodec) NewEncoder(w io.Writer) Encoder { return &stringEncoder{w: w} }
+
+// NewDecoder implements Codec.
+func (StringCodec) NewDecoder(r ByteReader) Decoder { return &stringDecoder{r: r} }
+
+type stringEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *stringEncoder) Encode(v interface{}) error {
+	s, ok := v.(string)
+	if !ok {
+		return &TypeError{Codec: "string", Value: v}
+	}
+	e.buf = binary.AppendUvarint(e.buf[:0], uint64(len(s)))
+	e.buf = append(e.buf, s...)
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// maxStringLen is the longest string a stringDecoder accepts from a reader without a Len method.
+const maxStringLen = 1 << 30
+
+type stringDecoder struct {
+	r   ByteReader
+	buf []byte
+}
+
+func (d *stringDecoder) Decode() (interface{}, error) {
+	ln, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	// the shard sections are decoded from a *bytes.Reader, the string can't be longer than what is left.
+	if lr, ok := d.r.(interface{ Len() int }); ok && ln > uint64(lr.Len()) {
+		return nil, ErrCorrupt
+	} else if ln > maxStringLen {
+		return nil, ErrCorrupt
+	}
+	if cap(d.buf) < int(ln) {
+		d.buf = make([]byte, ln)
+	}
+	d.buf = d.buf[:ln]
+	if _, err = io.ReadFull(d.r, d.buf); err != nil {
+		return nil, err
+	}
+	return string(d.buf), nil
+}
+
+// Uint64Codec encodes uint64s as uvarints.
+type Uint64Codec struct{}
+
+// ID implements Codec.
+func (Uint64Codec) ID() uint16 { return Uint64CodecID }
+
+// NewEncoder implements Codec.
+func (Uint64Codec) NewEncoder(w io.Writer) Encoder { return &uint64Encoder{w: w} }
+
+// NewDecoder implements Codec.
+func (Uint64Codec) NewDecoder(r ByteReader) Decoder { return &uint64Decoder{r: r} }
+
+type uint64Encoder struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *uint64Encoder) Encode(v interface{}) error {
+	u, ok := v.(uint64)
+	if !ok {
+		return &TypeError{Codec: "uint64", Value: v}
+	}
+	_, err := e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], u)])
+	return err
+}
+
+type uint64Decoder struct {
+	r ByteReader
+}
+
+func (d *uint64Decoder) Decode() (interface{}, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+// GobCodec encodes values using encoding/gob, custom types must be registered with gob.Register.
+// The type information is only written once per shard.
+type GobCodec struct{}
+
+// ID implements Codec.
+func (GobCodec) ID() uint16 { return GobCodecID }
+
+// NewEncoder implements Codec.
+func (GobCodec) NewEncoder(w io.Writer) Encoder { return gobEncoder{gob.NewEncoder(w)} }
+
+// NewDecoder implements Codec.
+func (GobCodec) NewDecoder(r ByteReader) Decoder { return gobDecoder{gob.NewDecoder(r)} }
+
+type gobEncoder struct {
+	enc *gob.Encoder
+}
+
+func (e gobEncoder) Encode(v interface{}) error { return e.enc.Encode(&v) }
+
+type gobDecoder struct {
+	dec *gob.Decoder
+}
+
+func (d gobDecoder) Decode() (v interface{}, err error) {
+	err = d.dec.Decode(&v)
+	return
+}
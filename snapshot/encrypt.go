@@ -0,0 +1,269 @@
+package snapshot
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An encrypted stream is a header followed by AES-GCM sealed chunks:
+//
+//	header: "CENC" | version uint8 | chunk size uint32 | nonce prefix [7]byte
+//	chunk:  length uint32 | sealed [length]byte
+//
+// The nonce of a chunk is the prefix, its index as a big endian uint32 and 1 for the last chunk or 0 otherwise,
+// the header is the additional data of every chunk, so chunks can't be modified, reordered, dropped or moved
+// to another stream without failing authentication.
+
+// DefaultChunkSize is the size of the plaintext of every encrypted chunk.
+const DefaultChunkSize = 64 << 10
+
+const (
+	encMagic     = "CENC"
+	encVersion   = 1
+	encHeaderLen = 4 + 1 + 4 + 7
+
+	maxChunkSize = 16 << 20
+)
+
+var (
+	// ErrTruncated is returned when an encrypted stream ends before its last chunk.
+	ErrTruncated = errors.New("snapshot: encrypted stream is truncated")
+	// ErrTrailingData is returned when an encrypted stream has data after its last chunk.
+	ErrTrailingData = errors.New("snapshot: data after the last encrypted chunk")
+)
+
+// AuthError is returned when an encrypted chunk fails authentication, because it was modified
+// or the key is wrong.
+type AuthError struct {
+	Chunk int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("snapshot: encrypted chunk %d failed authentication", e.Chunk)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	hdr   [encHeaderLen]byte
+	nonce [12]byte
+	buf   []byte
+	out   []byte
+	seq   uint32
+	err   error
+}
+
+// NewEncryptWriter writes the header of an encrypted stream to w and returns a writer that seals every chunkSize
+// bytes with AES-GCM, the key must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+// chunkSize defaults to DefaultChunkSize if it is < 1.
+// Close must be called to write the last chunk, it doesn't close w.
+func NewEncryptWriter(w io.Writer, key []byte, chunkSize int) (io.WriteCloser, error) {
+	if chunkSize < 1 {
+		chunkSize = DefaultChunkSize
+	} else if chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("snapshot: chunk size %d is larger than %d", chunkSize, maxChunkSize)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ew := &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, chunkSize)}
+	copy(ew.hdr[:], encMagic)
+	ew.hdr[4] = encVersion
+	binary.BigEndian.PutUint32(ew.hdr[5:], uint32(chunkSize))
+	if _, err = io.ReadFull(rand.Reader, ew.hdr[9:]); err != nil {
+		return nil, err
+	}
+	copy(ew.nonce[:], ew.hdr[9:])
+
+	if _, err = w.Write(ew.hdr[:]); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+// Write buffers p and seals full chunks, a full chunk is only sealed once more data is written,
+// so the last chunk is never empty unless the stream is.
+func (ew *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 && ew.err == nil {
+		if len(ew.buf) == cap(ew.buf) {
+			ew.seal(false)
+			continue
+		}
+		c := copy(ew.buf[len(ew.buf):cap(ew.buf)], p)
+		ew.buf = ew.buf[:len(ew.buf)+c]
+		n, p = n+c, p[c:]
+	}
+	return n, ew.err
+}
+
+// Close seals and writes the last chunk.
+func (ew *encryptWriter) Close() error {
+	if ew.err == nil {
+		if ew.seal(true); ew.err == nil {
+			ew.err = errWriteClosed
+			return nil
+		}
+	}
+	if ew.err == errWriteClosed {
+		return nil
+	}
+	return ew.err
+}
+
+var errWriteClosed = errors.New("snapshot: write to a closed encrypted stream")
+
+func (ew *encryptWriter) seal(last bool) {
+	if ew.seq == ^uint32(0) {
+		ew.err = errors.New("snapshot: too many encrypted chunks")
+		return
+	}
+
+	binary.BigEndian.PutUint32(ew.nonce[7:], ew.seq)
+	if ew.nonce[11] = 0; last {
+		ew.nonce[11] = 1
+	}
+
+	ew.out = append(ew.out[:0], 0, 0, 0, 0)
+	ew.out = ew.aead.Seal(ew.out, ew.nonce[:], ew.buf, ew.hdr[:])
+	binary.BigEndian.PutUint32(ew.out, uint32(len(ew.out)-4))
+
+	_, ew.err = ew.w.Write(ew.out)
+	ew.buf = ew.buf[:0]
+	ew.seq++
+}
+
+type decryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	hdr   [encHeaderLen]byte
+	nonce [12]byte
+	in    []byte
+	out   []byte
+	buf   []byte
+	seq   uint32
+	last  bool
+	err   error
+}
+
+// NewDecryptReader reads the header of a stream written by NewEncryptWriter and returns a reader that
+// authenticates and decrypts one chunk at a time, modified chunks return an *AuthError and truncated streams ErrTruncated.
+// Close reads and authenticates the rest of the stream, callers that stop reading early, like LoadFrom after the
+// end marker, must check its error to detect truncation and trailing data.
+func NewDecryptReader(r io.Reader, key []byte) (io.ReadCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &decryptReader{r: r, aead: aead}
+	if _, err = io.ReadFull(r, dr.hdr[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if string(dr.hdr[:4]) != encMagic {
+		return nil, ErrBadMagic
+	}
+	if dr.hdr[4] != encVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	if cs := binary.BigEndian.Uint32(dr.hdr[5:]); cs == 0 || cs > maxChunkSize {
+		return nil, fmt.Errorf("snapshot: invalid chunk size %d", cs)
+	}
+	copy(dr.nonce[:], dr.hdr[9:])
+
+	return dr, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (n int, err error) {
+	for len(dr.buf) == 0 && dr.err == nil {
+		dr.next()
+	}
+	if len(dr.buf) > 0 {
+		n = copy(p, dr.buf)
+		dr.buf = dr.buf[n:]
+		return n, nil
+	}
+	return 0, dr.err
+}
+
+// Close drains the stream and returns any error found in the remaining chunks.
+func (dr *decryptReader) Close() error {
+	for dr.err == nil {
+		dr.buf = dr.buf[:0]
+		dr.next()
+	}
+	if dr.err == io.EOF {
+		return nil
+	}
+	return dr.err
+}
+
+// next reads and opens the next chunk into buf, it sets err to io.EOF after the last chunk.
+func (dr *decryptReader) next() {
+	if dr.last {
+		var b [1]byte
+		if n, _ := io.ReadFull(dr.r, b[:]); n > 0 {
+			dr.err = ErrTrailingData
+		} else {
+			dr.err = io.EOF
+		}
+		return
+	}
+
+	var ln [4]byte
+	if _, err := io.ReadFull(dr.r, ln[:]); err != nil {
+		dr.err = truncated(err)
+		return
+	}
+
+	chunkSize := binary.BigEndian.Uint32(dr.hdr[5:])
+	n := binary.BigEndian.Uint32(ln[:])
+	if n < uint32(dr.aead.Overhead()) || n > chunkSize+uint32(dr.aead.Overhead()) {
+		dr.err = &AuthError{Chunk: int(dr.seq)}
+		return
+	}
+
+	if cap(dr.in) < int(n) {
+		dr.in = make([]byte, n)
+	}
+	dr.in = dr.in[:n]
+	if _, err := io.ReadFull(dr.r, dr.in); err != nil {
+		dr.err = truncated(err)
+		return
+	}
+
+	binary.BigEndian.PutUint32(dr.nonce[7:], dr.seq)
+	for _, last := range [...]byte{0, 1} {
+		dr.nonce[11] = last
+		out, err := dr.aead.Open(dr.out[:0], dr.nonce[:], dr.in, dr.hdr[:])
+		if err == nil {
+			dr.out, dr.buf, dr.last = out, out, last == 1
+			dr.seq++
+			return
+		}
+	}
+
+	dr.err = &AuthError{Chunk: int(dr.seq)}
+}
+
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
@@ -0,0 +1,304 @@
+// Package snapshot implements the versioned binary format used by CMap.SaveTo and LoadFrom.
+//
+// A snapshot is a header followed by any number of shard sections and an end marker:
+//
+//	header:  "CMAP" | version uint8 | key codec uint16 | value codec uint16 | shards uint32 | entries uint64 | seed uint64
+//	shard:   index uint32 | entries uint32 | length uint32 | payload [length]byte | crc32(payload) uint32
+//	end:     0xFFFFFFFF
+//
+// All the integers are big endian, the payload is the key and value of every entry encoded with their codecs.
+// The header's entry count is the length of the map when the snapshot started, the exact counts are in the
+// shard sections. The seed is the hash seed of the map, version 1 snapshots don't have it.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Version is the current version of the format.
+const Version = 2
+
+const (
+	magic     = "CMAP"
+	endMarker = ^uint32(0)
+	headerLen = 4 + 1 + 2 + 2 + 4 + 8 // the version 1 header, without the seed
+)
+
+// the limits of the uint32 entry count and length of a shard section, variables so tests can lower them.
+var (
+	maxSectionEntries uint64 = math.MaxUint32
+	maxSectionLen     uint64 = math.MaxUint32
+)
+
+var (
+	// ErrBadMagic is returned when the input isn't a snapshot.
+	ErrBadMagic = errors.New("snapshot: bad magic")
+	// ErrUnsupportedVersion is returned for snapshots written by a newer version of the format.
+	ErrUnsupportedVersion = errors.New("snapshot: unsupported version")
+	// ErrCorrupt is returned when a length, shard count or shard index in the snapshot is out of range,
+	// or when a shard section has bytes left after its entries.
+	ErrCorrupt = errors.New("snapshot: corrupt snapshot")
+	// ErrShardTooLarge is returned by WriteShard when a shard has more than 2^32-1 entries or encodes to more
+	// than 4 GiB, the section header can't hold it.
+	ErrShardTooLarge = errors.New("snapshot: shard too large")
+)
+
+// Header describes the content of a snapshot.
+type Header struct {
+	Version    uint8
+	KeyCodec   uint16
+	ValueCodec uint16
+	Shards     uint32
+	Entries    uint64
+	Seed       uint64
+}
+
+// ChecksumError is returned when the checksum of a shard section doesn't match its payload.
+type ChecksumError struct {
+	Shard int
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("snapshot: checksum mismatch in shard %d", e.Shard)
+}
+
+// UnknownCodecError is returned when a snapshot uses a codec that isn't registered.
+type UnknownCodecError struct {
+	ID uint16
+}
+
+func (e *UnknownCodecError) Error() string {
+	return fmt.Sprintf("snapshot: unknown codec id %d", e.ID)
+}
+
+// TypeError is returned when a codec is asked to encode a value of the wrong type, or when a decoded key or
+// value doesn't have the type of the map it is loaded into.
+type TypeError struct {
+	Codec  string // the codec, or "key" or "value" when decoding
+	Value  interface{}
+	Decode bool
+}
+
+func (e *TypeError) Error() string {
+	if e.Decode {
+		return fmt.Sprintf("snapshot: can't load a decoded %T as a %s of the map", e.Value, e.Codec)
+	}
+	return fmt.Sprintf("snapshot: the %s codec can't encode %T", e.Codec, e.Value)
+}
+
+// Writer writes a snapshot one shard at a time.
+type Writer struct {
+	w      io.Writer
+	buf    bytes.Buffer
+	kc, vc Codec
+}
+
+// NewWriter writes the header to w and returns a Writer, Close must be called after the last shard.
+func NewWriter(w io.Writer, kc, vc Codec, shards int, entries int) (*Writer, error) {
+	return NewWriterSeed(w, kc, vc, shards, entries, 0)
+}
+
+// NewWriterSeed is like NewWriter but also stores the hash seed of the map.
+func NewWriterSeed(w io.Writer, kc, vc Codec, shards int, entries int, seed uint64) (*Writer, error) {
+	var hdr [headerLen + 8]byte
+	copy(hdr[:], magic)
+	hdr[4] = Version
+	binary.BigEndian.PutUint16(hdr[5:], kc.ID())
+	binary.BigEndian.PutUint16(hdr[7:], vc.ID())
+	binary.BigEndian.PutUint32(hdr[9:], uint32(shards))
+	binary.BigEndian.PutUint64(hdr[13:], uint64(entries))
+	binary.BigEndian.PutUint64(hdr[21:], seed)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, kc: kc, vc: vc}, nil
+}
+
+// WriteShard calls fn with an add func that encodes a single entry, then writes the shard section.
+// The section is buffered in memory, so fn may hold the shard's lock without waiting on w.
+func (sw *Writer) WriteShard(index int, fn func(add func(key, val interface{}) error) error) error {
+	sw.buf.Reset()
+	sw.buf.Write(make([]byte, 12)) // index, entries and length are filled after encoding
+
+	var (
+		n      uint64
+		ke, ve = sw.kc.NewEncoder(&sw.buf), sw.vc.NewEncoder(&sw.buf)
+	)
+
+	err := fn(func(key, val interface{}) error {
+		if n == maxSectionEntries {
+			return ErrShardTooLarge
+		}
+		if err := ke.Encode(key); err != nil {
+			return err
+		}
+		if err := ve.Encode(val); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p := sw.buf.Bytes()
+	if uint64(len(p)-12) > maxSectionLen {
+		return ErrShardTooLarge
+	}
+	binary.BigEndian.PutUint32(p, uint32(index))
+	binary.BigEndian.PutUint32(p[4:], uint32(n))
+	binary.BigEndian.PutUint32(p[8:], uint32(len(p)-12))
+
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(p[12:]))
+	sw.buf.Write(crc[:])
+
+	_, err = sw.w.Write(sw.buf.Bytes())
+	return err
+}
+
+// Close writes the end marker, it doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	var end [4]byte
+	binary.BigEndian.PutUint32(end[:], endMarker)
+	_, err := sw.w.Write(end[:])
+	return err
+}
+
+// Reader reads a snapshot one shard at a time.
+type Reader struct {
+	Header
+
+	r      io.Reader
+	buf    []byte
+	kc, vc Codec
+}
+
+// NewReader reads and validates the header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	var hdr [headerLen + 8]byte
+	if _, err := io.ReadFull(r, hdr[:headerLen]); err != nil {
+		return nil, err
+	}
+	if string(hdr[:4]) != magic {
+		return nil, ErrBadMagic
+	}
+
+	sr := &Reader{
+		Header: Header{
+			Version:    hdr[4],
+			KeyCodec:   binary.BigEndian.Uint16(hdr[5:]),
+			ValueCodec: binary.BigEndian.Uint16(hdr[7:]),
+			Shards:     binary.BigEndian.Uint32(hdr[9:]),
+			Entries:    binary.BigEndian.Uint64(hdr[13:]),
+		},
+		r: r,
+	}
+
+	if sr.Version == 0 || sr.Version > Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	if n := sr.Shards; n == 0 || n&(n-1) != 0 {
+		return nil, ErrCorrupt
+	}
+
+	if sr.Version > 1 {
+		if _, err := io.ReadFull(r, hdr[headerLen:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		sr.Seed = binary.BigEndian.Uint64(hdr[headerLen:])
+	}
+
+	var ok bool
+	if sr.kc, ok = Lookup(sr.KeyCodec); !ok {
+		return nil, &UnknownCodecError{sr.KeyCodec}
+	}
+	if sr.vc, ok = Lookup(sr.ValueCodec); !ok {
+		return nil, &UnknownCodecError{sr.ValueCodec}
+	}
+
+	return sr, nil
+}
+
+// ReadShard reads the next shard section, verifies its checksum and calls fn for every entry.
+// It returns io.EOF after the last shard, and ErrCorrupt if the shard index is out of range or the section
+// has bytes left after its entries.
+// The section is read as it arrives, so a bogus length in a truncated snapshot doesn't allocate it all upfront.
+func (sr *Reader) ReadShard(fn func(key, val interface{}) error) (index int, err error) {
+	var hdr [12]byte
+	if _, err = io.ReadFull(sr.r, hdr[:4]); err != nil {
+		return -1, unexpectedEOF(err)
+	}
+	if idx := binary.BigEndian.Uint32(hdr[:]); idx == endMarker {
+		return -1, io.EOF
+	}
+	if _, err = io.ReadFull(sr.r, hdr[4:]); err != nil {
+		return -1, unexpectedEOF(err)
+	}
+
+	index = int(binary.BigEndian.Uint32(hdr[:]))
+	if uint32(index) >= sr.Shards {
+		return -1, ErrCorrupt
+	}
+	n := binary.BigEndian.Uint32(hdr[4:])
+	ln := int64(binary.BigEndian.Uint32(hdr[8:]))
+
+	var p []byte
+	if p, err = sr.readSection(ln + 4); err != nil {
+		return index, unexpectedEOF(err)
+	}
+	if crc32.ChecksumIEEE(p[:ln]) != binary.BigEndian.Uint32(p[ln:]) {
+		return index, &ChecksumError{Shard: index}
+	}
+
+	br := bytes.NewReader(p[:ln])
+	kd, vd := sr.kc.NewDecoder(br), sr.vc.NewDecoder(br)
+	for i := uint32(0); i < n; i++ {
+		var k, v interface{}
+		if k, err = kd.Decode(); err != nil {
+			return index, unexpectedEOF(err)
+		}
+		if v, err = vd.Decode(); err != nil {
+			return index, unexpectedEOF(err)
+		}
+		if err = fn(k, v); err != nil {
+			return index, err
+		}
+	}
+
+	if br.Len() != 0 {
+		return index, ErrCorrupt
+	}
+	return index, nil
+}
+
+// readSection reads the next n bytes, if they don't fit in sr.buf the buffer grows as the bytes arrive.
+func (sr *Reader) readSection(n int64) ([]byte, error) {
+	if int64(cap(sr.buf)) >= n {
+		p := sr.buf[:n]
+		_, err := io.ReadFull(sr.r, p)
+		return p, err
+	}
+
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, sr.r, n); err != nil {
+		return nil, err
+	}
+	sr.buf = b.Bytes()
+	return sr.buf, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
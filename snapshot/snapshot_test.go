@@ -0,0 +1,241 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func writeTestSnapshot(t *testing.T) []byte {
+	var buf bytes.Buffer
+	sw, err := NewWriter(&buf, StringCodec{}, GobCodec{}, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sw.WriteShard(0, func(add func(k, v interface{}) error) error {
+		if err := add("a", 1); err != nil {
+			return err
+		}
+		return add("b", "2")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sw.WriteShard(1, func(add func(k, v interface{}) error) error { return add("c", 3.5) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = sw.WriteShard(1, func(add func(k, v interface{}) error) error { return add(1, 1) }); err == nil {
+		t.Fatal("expected a *TypeError")
+	}
+
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshot(t *testing.T) {
+	p := writeTestSnapshot(t)
+
+	sr, err := NewReader(bytes.NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Header != (Header{Version: Version, KeyCodec: StringCodecID, ValueCodec: GobCodecID, Shards: 2, Entries: 3}) {
+		t.Fatalf("unexpected header: %+v", sr.Header)
+	}
+
+	got := map[interface{}]interface{}{}
+	for {
+		_, err := sr.ReadShard(func(k, v interface{}) error {
+			got[k] = v
+			return nil
+		})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 3 || got["a"] != 1 || got["b"] != "2" || got["c"] != 3.5 {
+		t.Fatalf("unexpected entries: %v", got)
+	}
+}
+
+func readAll(p []byte) error {
+	sr, err := NewReader(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err = sr.ReadShard(func(k, v interface{}) error { return nil }); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	p := writeTestSnapshot(t)
+
+	bad := append([]byte(nil), p...)
+	bad[len(bad)-10] ^= 0xff
+	if err := readAll(bad); err == nil {
+		t.Fatal("expected an error")
+	} else if _, ok := err.(*ChecksumError); !ok {
+		t.Fatalf("expected a *ChecksumError, got %v", err)
+	}
+
+	if err := readAll(p[:len(p)-6]); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	if err := readAll([]byte("not a snapshot at all")); err != ErrBadMagic {
+		t.Fatalf("expected ErrBadMagic, got %v", err)
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	var buf bytes.Buffer
+	sw, err := NewWriterSeed(&buf, StringCodec{}, GobCodec{}, 1, 1, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sw.WriteShard(0, func(add func(k, v interface{}) error) error { return add("a", 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil || sr.Seed != 42 {
+		t.Fatalf("seed = %d, err = %v", sr.Seed, err)
+	}
+
+	// a version 1 header doesn't have the seed
+	p := append([]byte(nil), buf.Bytes()[:headerLen]...)
+	p[4] = 1
+	p = append(p, buf.Bytes()[headerLen+8:]...)
+	if err = readAll(p); err != nil {
+		t.Fatal(err)
+	}
+	if sr, err = NewReader(bytes.NewReader(p)); err != nil || sr.Version != 1 || sr.Seed != 0 {
+		t.Fatalf("unexpected header %+v, err = %v", sr.Header, err)
+	}
+}
+
+// rawSnapshot returns a snapshot header with the string codec for keys and values, followed by sections.
+func rawSnapshot(shards uint32, sections ...[]byte) []byte {
+	hdr := make([]byte, headerLen+8)
+	copy(hdr, magic)
+	hdr[4] = Version
+	binary.BigEndian.PutUint16(hdr[5:], StringCodecID)
+	binary.BigEndian.PutUint16(hdr[7:], StringCodecID)
+	binary.BigEndian.PutUint32(hdr[9:], shards)
+	for _, s := range sections {
+		hdr = append(hdr, s...)
+	}
+	return binary.BigEndian.AppendUint32(hdr, endMarker)
+}
+
+// rawSection returns a shard section with a valid checksum, ln overrides the payload length if it isn't 0.
+func rawSection(index, entries, ln uint32, payload []byte) []byte {
+	if ln == 0 {
+		ln = uint32(len(payload))
+	}
+	p := binary.BigEndian.AppendUint32(nil, index)
+	p = binary.BigEndian.AppendUint32(p, entries)
+	p = binary.BigEndian.AppendUint32(p, ln)
+	p = append(p, payload...)
+	return binary.BigEndian.AppendUint32(p, crc32.ChecksumIEEE(payload))
+}
+
+func TestCorrupt(t *testing.T) {
+	valid := append(binary.AppendUvarint(nil, 1), 'a')
+	valid = append(binary.AppendUvarint(valid, 1), 'b')
+
+	tests := []struct {
+		name string
+		p    []byte
+		err  error
+	}{
+		{"valid", rawSnapshot(4, rawSection(1, 1, 0, valid)), nil},
+		{"zero shards", rawSnapshot(0), ErrCorrupt},
+		{"not a power of 2", rawSnapshot(3), ErrCorrupt},
+		{"many shards", rawSnapshot(1 << 31), nil},
+		{"shard index", rawSnapshot(4, rawSection(4, 1, 0, valid)), ErrCorrupt},
+		{"huge section", rawSnapshot(4, rawSection(0, 1, 1<<31, valid)), io.ErrUnexpectedEOF},
+		{"huge string", rawSnapshot(4, rawSection(0, 1, 0, binary.AppendUvarint(nil, 1<<40))), ErrCorrupt},
+		{"long string", rawSnapshot(4, rawSection(0, 1, 0, append(binary.AppendUvarint(nil, 10), "abc"...))), ErrCorrupt},
+		{"trailing bytes", rawSnapshot(4, rawSection(0, 1, 0, append(valid, 0))), ErrCorrupt},
+		{"missing entries", rawSnapshot(4, rawSection(0, 0, 0, valid)), ErrCorrupt},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			before := ms.TotalAlloc
+
+			err := readAll(tc.p)
+			if err != tc.err {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+
+			runtime.ReadMemStats(&ms)
+			if n := ms.TotalAlloc - before; n > 1<<20 {
+				t.Fatalf("allocated %d bytes", n)
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	p := writeTestSnapshot(t)
+	for i := 0; i < len(p); i++ {
+		if err := readAll(p[:i]); err == nil {
+			t.Fatalf("%d: expected an error reading a truncated snapshot", i)
+		}
+	}
+}
+
+func TestWriteShardTooLarge(t *testing.T) {
+	defer func(entries, ln uint64) { maxSectionEntries, maxSectionLen = entries, ln }(maxSectionEntries, maxSectionLen)
+	maxSectionEntries, maxSectionLen = 2, 8
+
+	sw, err := NewWriter(io.Discard, StringCodec{}, StringCodec{}, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addN := func(n int, v string) func(add func(k, v interface{}) error) error {
+		return func(add func(k, v interface{}) error) error {
+			for i := 0; i < n; i++ {
+				if err := add(string(rune('a'+i)), v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if err = sw.WriteShard(0, addN(2, "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err = sw.WriteShard(0, addN(3, "b")); err != ErrShardTooLarge {
+		t.Fatalf("expected ErrShardTooLarge for the entries, got %v", err)
+	}
+	if err = sw.WriteShard(0, addN(2, "bcd")); err != ErrShardTooLarge {
+		t.Fatalf("expected ErrShardTooLarge for the length, got %v", err)
+	}
+}
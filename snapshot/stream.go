@@ -0,0 +1,171 @@
+package snapshot
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Compression selects the compress package used by NewStreamWriter and NewStreamReader.
+type Compression uint8
+
+// The supported compressions, all from the stdlib compress packages.
+const (
+	CompressNone Compression = iota
+	CompressGzip
+	CompressZlib
+	CompressFlate
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressGzip:
+		return "gzip"
+	case CompressZlib:
+		return "zlib"
+	case CompressFlate:
+		return "flate"
+	}
+	return fmt.Sprintf("Compression(%d)", uint8(c))
+}
+
+// StreamOptions describes the layers of a snapshot stream, the data is compressed then encrypted,
+// a nil *StreamOptions or the zero value is a plain stream.
+// The same options must be passed to NewStreamReader, they aren't stored in the stream.
+type StreamOptions struct {
+	Compression Compression
+
+	// Level is the compression level, 0 uses flate.DefaultCompression.
+	Level int
+
+	// Key enables AES-GCM encryption if it is set, it must be 16, 24 or 32 bytes, see NewEncryptWriter.
+	Key []byte
+
+	// ChunkSize is the size of the encrypted chunks, defaults to DefaultChunkSize.
+	ChunkSize int
+}
+
+// NewStreamWriter returns a writer that compresses and encrypts the data written to it according to opts,
+// ex. cm.SaveTo(sw).
+// Close must be called to flush all the layers, it doesn't close w.
+func NewStreamWriter(w io.Writer, opts *StreamOptions) (io.WriteCloser, error) {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+
+	var (
+		sw  = &streamWriter{Writer: w}
+		err error
+	)
+
+	if opts.Key != nil {
+		var ew io.WriteCloser
+		if ew, err = NewEncryptWriter(w, opts.Key, opts.ChunkSize); err != nil {
+			return nil, err
+		}
+		sw.Writer, sw.closers = ew, append(sw.closers, ew)
+	}
+
+	level := opts.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	var cw io.WriteCloser
+	switch opts.Compression {
+	case CompressNone:
+	case CompressGzip:
+		cw, err = gzip.NewWriterLevel(sw.Writer, level)
+	case CompressZlib:
+		cw, err = zlib.NewWriterLevel(sw.Writer, level)
+	case CompressFlate:
+		cw, err = flate.NewWriter(sw.Writer, level)
+	default:
+		err = fmt.Errorf("snapshot: unknown compression %v", opts.Compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cw != nil {
+		// the compressor must be closed before the encryptor
+		sw.Writer, sw.closers = cw, append([]io.Closer{cw}, sw.closers...)
+	}
+
+	return sw, nil
+}
+
+type streamWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (sw *streamWriter) Close() (err error) {
+	for _, c := range sw.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// NewStreamReader returns a reader that decrypts and decompresses a stream written by NewStreamWriter with the same opts.
+// Close must be called and its error checked after reading, it verifies the encrypted stream wasn't truncated.
+func NewStreamReader(r io.Reader, opts *StreamOptions) (io.ReadCloser, error) {
+	if opts == nil {
+		opts = &StreamOptions{}
+	}
+
+	var (
+		sr  = &streamReader{Reader: r}
+		err error
+	)
+
+	if opts.Key != nil {
+		var dr io.ReadCloser
+		if dr, err = NewDecryptReader(r, opts.Key); err != nil {
+			return nil, err
+		}
+		sr.Reader, sr.closers = dr, append(sr.closers, dr)
+	}
+
+	var cr io.ReadCloser
+	switch opts.Compression {
+	case CompressNone:
+	case CompressGzip:
+		cr, err = gzip.NewReader(sr.Reader)
+	case CompressZlib:
+		cr, err = zlib.NewReader(sr.Reader)
+	case CompressFlate:
+		cr = flate.NewReader(sr.Reader)
+	default:
+		err = fmt.Errorf("snapshot: unknown compression %v", opts.Compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cr != nil {
+		sr.Reader, sr.closers = cr, append([]io.Closer{cr}, sr.closers...)
+	}
+
+	return sr, nil
+}
+
+type streamReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (sr *streamReader) Close() (err error) {
+	for _, c := range sr.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{42}, 32)
+
+func writeStream(t *testing.T, p []byte, opts *StreamOptions) []byte {
+	var buf bytes.Buffer
+	sw, err := NewStreamWriter(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(p) > 0 { // odd sized writes to cross chunk boundaries
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err = sw.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readStream(p []byte, opts *StreamOptions) ([]byte, error) {
+	sr, err := NewStreamReader(bytes.NewReader(p), opts)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(sr)
+	if cerr := sr.Close(); err == nil {
+		err = cerr
+	}
+	return out, err
+}
+
+func TestStream(t *testing.T) {
+	data := make([]byte, 100000)
+	rand.Read(data[:50000]) // half random, half zeros
+
+	for _, opts := range []*StreamOptions{
+		nil,
+		{Compression: CompressGzip},
+		{Compression: CompressZlib, Level: 9},
+		{Compression: CompressFlate},
+		{Key: testKey[:16]},
+		{Key: testKey, ChunkSize: 4096},
+		{Key: testKey, ChunkSize: 1000},
+		{Key: testKey[:24], Compression: CompressGzip, ChunkSize: 512},
+	} {
+		for _, in := range [][]byte{nil, data[:10], data} {
+			p := writeStream(t, in, opts)
+			out, err := readStream(p, opts)
+			if err != nil {
+				t.Fatalf("%+v: %v", opts, err)
+			}
+			if !bytes.Equal(out, in) {
+				t.Fatalf("%+v: data mismatch, got %d bytes, expected %d", opts, len(out), len(in))
+			}
+		}
+	}
+
+	p := writeStream(t, data, &StreamOptions{Compression: CompressGzip})
+	if len(p) > 60000 {
+		t.Fatalf("expected compression, got %d bytes", len(p))
+	}
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	snap := writeTestSnapshot(t)
+	opts := &StreamOptions{Compression: CompressFlate, Key: testKey}
+	p := writeStream(t, snap, opts)
+
+	sr, err := NewStreamReader(bytes.NewReader(p), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for {
+		if _, err = r.ReadShard(func(k, v interface{}) error { n++; return nil }); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sr.Close(); err != nil || n != 3 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	data := bytes.Repeat([]byte("session-token "), 1000)
+	opts := &StreamOptions{Key: testKey, ChunkSize: 1024}
+	p := writeStream(t, data, opts)
+
+	if bytes.Contains(p, []byte("session-token")) {
+		t.Fatal("the stream isn't encrypted")
+	}
+
+	var ae *AuthError
+	bad := append([]byte(nil), p...)
+	bad[encHeaderLen+4+2000] ^= 1 // the second chunk
+	if _, err := readStream(bad, opts); !errors.As(err, &ae) || ae.Chunk != 1 {
+		t.Fatalf("expected an *AuthError for chunk 1, got %v", err)
+	}
+
+	if _, err := readStream(p, &StreamOptions{Key: bytes.Repeat([]byte{1}, 32)}); !errors.As(err, &ae) || ae.Chunk != 0 {
+		t.Fatalf("expected an *AuthError for the wrong key, got %v", err)
+	}
+
+	chunk := 4 + 1024 + 16
+	for _, n := range []int{len(p) - 1, len(p) - 20, encHeaderLen + chunk, encHeaderLen} {
+		if _, err := readStream(p[:n], opts); err != ErrTruncated {
+			t.Fatalf("%d: expected ErrTruncated, got %v", n, err)
+		}
+	}
+
+	// swapping two chunks
+	swapped := append([]byte(nil), p[:encHeaderLen]...)
+	swapped = append(swapped, p[encHeaderLen+chunk:encHeaderLen+2*chunk]...)
+	swapped = append(swapped, p[encHeaderLen:encHeaderLen+chunk]...)
+	swapped = append(swapped, p[encHeaderLen+2*chunk:]...)
+	if _, err := readStream(swapped, opts); !errors.As(err, &ae) || ae.Chunk != 0 {
+		t.Fatalf("expected an *AuthError for swapped chunks, got %v", err)
+	}
+
+	if _, err := readStream(append(p[:len(p):len(p)], 0), opts); err != ErrTrailingData {
+		t.Fatalf("expected ErrTrailingData, got %v", err)
+	}
+
+	if _, err := NewStreamWriter(ioutil.Discard, &StreamOptions{Key: []byte("short")}); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
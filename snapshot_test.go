@@ -0,0 +1,124 @@
+package cmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/OneOfOne/cmap"
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+func TestSnapshot(t *testing.T) {
+	cm := cmap.NewSize(16)
+	for i := 0; i < 1000; i++ {
+		cm.Set(i, float64(i))
+	}
+	cm.Set("str", "value")
+
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	lcm, err := cmap.LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lcm.NumShards() != cm.NumShards() || lcm.Len() != cm.Len() {
+		t.Fatalf("expected %d/%d, got %d/%d", cm.NumShards(), cm.Len(), lcm.NumShards(), lcm.Len())
+	}
+
+	cm.ForEach(func(k, v interface{}) bool {
+		if lv, ok := lcm.GetOK(k); !ok || lv != v {
+			t.Fatalf("%v: expected %v, got %v", k, v, lv)
+		}
+		return true
+	})
+}
+
+func TestSnapshotNil(t *testing.T) {
+	cm := cmap.NewSize(4)
+	cm.Set("a", nil)
+	cm.Set(nil, 1)
+	cm.Set("b", 2)
+
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lcm, err := cmap.LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := lcm.GetOK("a"); !ok || v != nil || lcm.Get(nil) != 1 || lcm.Len() != 3 {
+		t.Fatalf("unexpected map: %v", lcm.Keys())
+	}
+}
+
+func TestLoadFromManyShards(t *testing.T) {
+	// NewSize(1 << 13) would preallocate every shard for 1<<13 keys, so the snapshot is written by hand.
+	const n = 1 << 13
+	var buf bytes.Buffer
+	sw, err := snapshot.NewWriter(&buf, snapshot.GobCodec{}, snapshot.GobCodec{}, n, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		err = sw.WriteShard(i, func(add func(k, v interface{}) error) error {
+			if i == n-1 {
+				return add("key", "value")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lcm, err := cmap.LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lcm.NumShards() != n || lcm.Get("key") != "value" || lcm.Len() != 1 {
+		t.Fatalf("unexpected map: %d shards, %d keys", lcm.NumShards(), lcm.Len())
+	}
+}
+
+func TestLoadFromBadShardCount(t *testing.T) {
+	var buf bytes.Buffer
+	if err := cmap.NewSize(4).SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 8 and 1<<31 are valid shard counts, but the snapshot only has 4 shards.
+	for _, n := range []uint32{0, 3, 8, 1 << 31} {
+		p := append([]byte(nil), buf.Bytes()...)
+		binary.BigEndian.PutUint32(p[9:], n)
+		if _, err := cmap.LoadFrom(bytes.NewReader(p)); err != snapshot.ErrCorrupt {
+			t.Fatalf("%d shards: expected ErrCorrupt, got %v", n, err)
+		}
+	}
+
+	// the shards out of order.
+	buf.Reset()
+	sw, err := snapshot.NewWriter(&buf, snapshot.GobCodec{}, snapshot.GobCodec{}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{1, 0} {
+		if err = sw.WriteShard(i, func(func(k, v interface{}) error) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cmap.LoadFrom(&buf); err != snapshot.ErrCorrupt {
+		t.Fatalf("shards out of order: expected ErrCorrupt, got %v", err)
+	}
+}
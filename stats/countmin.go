@@ -0,0 +1,78 @@
+// Package stats implements the counters and reports used by the optional diagnostics of cmap.
+package stats
+
+import "github.com/OneOfOne/cmap/hashers"
+
+// CountMin is a count-min sketch, it estimates the frequency of hashed items using a fixed amount of memory.
+// Estimates are never lower than the real count, but may be higher if items collide in every row.
+// It is **NOT** safe for concurrent use.
+type CountMin struct {
+	counts []uint32
+	width  uint32
+	depth  uint32
+}
+
+// NewCountMin returns a CountMin with depth rows of width counters each.
+// width is rounded up to the next power of 2.
+func NewCountMin(width, depth int) *CountMin {
+	if width < 1 {
+		width = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+
+	w := uint32(1)
+	for w < uint32(width) {
+		w <<= 1
+	}
+
+	return &CountMin{
+		counts: make([]uint32, int(w)*depth),
+		width:  w,
+		depth:  uint32(depth),
+	}
+}
+
+// Add adds n to the counters of h and returns the new estimate.
+func (cm *CountMin) Add(h uint64, n uint32) (est uint32) {
+	h1, h2 := cm.split(h)
+	est = ^uint32(0)
+	for i := uint32(0); i < cm.depth; i++ {
+		idx := i*cm.width + (h1+i*h2)&(cm.width-1)
+		c := cm.counts[idx] + n
+		if c < n { // overflow
+			c = ^uint32(0)
+		}
+		cm.counts[idx] = c
+		if c < est {
+			est = c
+		}
+	}
+	return
+}
+
+// Estimate returns the estimated count of h.
+func (cm *CountMin) Estimate(h uint64) (est uint32) {
+	h1, h2 := cm.split(h)
+	est = ^uint32(0)
+	for i := uint32(0); i < cm.depth; i++ {
+		if c := cm.counts[i*cm.width+(h1+i*h2)&(cm.width-1)]; c < est {
+			est = c
+		}
+	}
+	return
+}
+
+// Reset zeroes all the counters.
+func (cm *CountMin) Reset() {
+	for i := range cm.counts {
+		cm.counts[i] = 0
+	}
+}
+
+// split uses double hashing to derive the per-row indices from a single hash.
+func (cm *CountMin) split(h uint64) (h1, h2 uint32) {
+	h = hashers.Mix64(h)
+	return uint32(h), uint32(h>>32) | 1
+}
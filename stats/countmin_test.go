@@ -0,0 +1,32 @@
+package stats
+
+import "testing"
+
+func TestCountMin(t *testing.T) {
+	cm := NewCountMin(100, 4)
+	if cm.width != 128 {
+		t.Fatalf("expected width to be rounded up to 128, got %d", cm.width)
+	}
+
+	for i := uint64(0); i < 1000; i++ {
+		cm.Add(i, 1)
+	}
+	for i := 0; i < 500; i++ {
+		cm.Add(42, 1)
+	}
+
+	if est := cm.Estimate(42); est < 501 {
+		t.Fatalf("estimate can't be lower than the real count: %d", est)
+	}
+
+	for i := uint64(0); i < 1000; i++ {
+		if est := cm.Estimate(i); est < 1 {
+			t.Fatalf("estimate of %d can't be lower than the real count: %d", i, est)
+		}
+	}
+
+	cm.Reset()
+	if est := cm.Estimate(42); est != 0 {
+		t.Fatalf("expected 0 after reset, got %d", est)
+	}
+}
@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// DurationHistogram is a lock-free histogram of durations using power of 2 buckets.
+// The zero value is ready to use and it is safe for concurrent use.
+type DurationHistogram struct {
+	counts [64]uint64
+	sum    uint64
+}
+
+// Observe records d.
+func (dh *DurationHistogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddUint64(&dh.counts[bucketOf(d)], 1)
+	atomic.AddUint64(&dh.sum, uint64(d))
+}
+
+// Snapshot returns a copy of the current state of the histogram.
+func (dh *DurationHistogram) Snapshot() (s DurationSnapshot) {
+	for i := range dh.counts {
+		c := atomic.LoadUint64(&dh.counts[i])
+		s.Buckets[i] = c
+		s.Count += c
+	}
+	s.Sum = time.Duration(atomic.LoadUint64(&dh.sum))
+	return
+}
+
+// DurationSnapshot is a point in time copy of a DurationHistogram.
+// Buckets[i] is the number of durations in the [BucketBound(i-1), BucketBound(i)) range.
+type DurationSnapshot struct {
+	Buckets [64]uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// Merge adds the counts of o to s.
+func (s *DurationSnapshot) Merge(o *DurationSnapshot) {
+	for i, c := range o.Buckets {
+		s.Buckets[i] += c
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+}
+
+// Mean returns the average duration.
+func (s *DurationSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns the upper bound of the bucket containing the q-th quantile (0 <= q <= 1).
+func (s *DurationSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+
+	want := uint64(q*float64(s.Count) + 0.5)
+	if want < 1 {
+		want = 1
+	}
+
+	var n uint64
+	for i, c := range s.Buckets {
+		if n += c; n >= want {
+			return BucketBound(i)
+		}
+	}
+	return BucketBound(len(s.Buckets) - 1)
+}
+
+func (s *DurationSnapshot) String() string {
+	return fmt.Sprintf("count: %d, mean: %v, p50: %v, p99: %v, max: %v",
+		s.Count, s.Mean(), s.Quantile(0.5), s.Quantile(0.99), s.Quantile(1))
+}
+
+// BucketBound returns the exclusive upper bound of bucket i.
+func BucketBound(i int) time.Duration {
+	if i >= 63 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Duration(1) << uint(i)
+}
+
+func bucketOf(d time.Duration) int {
+	return bits.Len64(uint64(d))
+}
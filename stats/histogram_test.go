@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	var dh DurationHistogram
+	for i := 1; i <= 100; i++ {
+		dh.Observe(time.Duration(i) * time.Microsecond)
+	}
+
+	s := dh.Snapshot()
+	if s.Count != 100 || s.Mean() != 50500*time.Nanosecond {
+		t.Fatalf("unexpected snapshot: %v", &s)
+	}
+
+	if p50 := s.Quantile(0.5); p50 < 50*time.Microsecond || p50 > 100*time.Microsecond {
+		t.Fatalf("unexpected p50: %v", p50)
+	}
+
+	if max := s.Quantile(1); max < 100*time.Microsecond {
+		t.Fatalf("unexpected max: %v", max)
+	}
+
+	s.Merge(&s)
+	if s.Count != 200 {
+		t.Fatalf("expected 200 after merge, got %d", s.Count)
+	}
+}
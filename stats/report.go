@@ -0,0 +1,211 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultHistogramBuckets is the number of buckets NewDistributionReport uses for the occupancy histogram.
+const DefaultHistogramBuckets = 10
+
+// DistributionReport describes how evenly entries are spread among shards.
+type DistributionReport struct {
+	Shards      int
+	Entries     int
+	EmptyShards int
+
+	Min    int
+	Max    int
+	Mean   float64
+	StdDev float64
+
+	// ChiSquare is Pearson's chi-square statistic against a uniform distribution.
+	ChiSquare float64
+	// Uniformity is ChiSquare divided by the degrees of freedom, a good hash stays close to 1,
+	// values much higher than 1 mean some shards are overloaded.
+	Uniformity float64
+
+	// Histogram is the number of shards per occupancy bucket, between Min and Max.
+	Histogram []HistogramBucket
+}
+
+// HistogramBucket is the number of shards holding between Low and High (inclusive) entries.
+type HistogramBucket struct {
+	Low    int
+	High   int
+	Shards int
+}
+
+// NewDistributionReport returns a report for the specific per-shard counts.
+func NewDistributionReport(counts []int) *DistributionReport {
+	r := &DistributionReport{Shards: len(counts)}
+	if len(counts) == 0 {
+		return r
+	}
+
+	r.Min, r.Max = counts[0], counts[0]
+	for _, c := range counts {
+		r.Entries += c
+		if c == 0 {
+			r.EmptyShards++
+		}
+		if c < r.Min {
+			r.Min = c
+		}
+		if c > r.Max {
+			r.Max = c
+		}
+	}
+
+	r.Mean = float64(r.Entries) / float64(r.Shards)
+	var variance float64
+	for _, c := range counts {
+		d := float64(c) - r.Mean
+		variance += d * d
+	}
+	r.StdDev = math.Sqrt(variance / float64(r.Shards))
+
+	if r.Entries > 0 {
+		r.ChiSquare = variance / r.Mean
+		if r.Shards > 1 {
+			r.Uniformity = r.ChiSquare / float64(r.Shards-1)
+		}
+	}
+
+	r.Histogram = histogram(counts, r.Min, r.Max, DefaultHistogramBuckets)
+	return r
+}
+
+func (r *DistributionReport) String() string {
+	return fmt.Sprintf("shards: %d, entries: %d, empty: %d, min: %d, max: %d, mean: %.2f, stddev: %.2f, chi2: %.2f, uniformity: %.3f",
+		r.Shards, r.Entries, r.EmptyShards, r.Min, r.Max, r.Mean, r.StdDev, r.ChiSquare, r.Uniformity)
+}
+
+func histogram(counts []int, min, max, n int) []HistogramBucket {
+	width := (max - min + n) / n
+	if width < 1 {
+		width = 1
+	}
+
+	out := make([]HistogramBucket, 0, n)
+	for low := min; low <= max; low += width {
+		out = append(out, HistogramBucket{Low: low, High: low + width - 1})
+	}
+
+	for _, c := range counts {
+		out[(c-min)/width].Shards++
+	}
+
+	return out
+}
+
+// HasherReport runs keys through fn and returns the resulting distribution among numShards shards.
+// fn can be any of the hashers in the hashers package, or any func with a matching signature:
+//
+//	func(string) uint32, func(string) uint64, func(interface{}) uint32, func(interface{}) uint64,
+//	func(uint32) uint32, func(uint64) uint32, func(uint64) uint64.
+//
+// Seeded hashers are called with a seed of 0, use HasherReportSeed to pick the seed.
+func HasherReport(keys []interface{}, fn interface{}, numShards int) (*DistributionReport, error) {
+	return HasherReportSeed(keys, fn, 0, numShards)
+}
+
+// HasherReportSeed is like HasherReport, but seeded hashers are called with seed, it accepts all the signatures
+// HasherReport does plus:
+//
+//	func(string, uint64) uint32, func(string, uint64) uint64,
+//	func(interface{}, uint64) uint32, func(interface{}, uint64) uint64.
+//
+// Any other hasher can be adapted with a closure, e.g. func(k string) uint64 { return myHash(k, seed) }.
+func HasherReportSeed(keys []interface{}, fn interface{}, seed uint64, numShards int) (*DistributionReport, error) {
+	if numShards < 1 {
+		return nil, fmt.Errorf("invalid number of shards: %d", numShards)
+	}
+
+	hashFn, err := hasherFunc(unseed(fn, seed))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, numShards)
+	for _, k := range keys {
+		h, err := hashFn(k)
+		if err != nil {
+			return nil, err
+		}
+		counts[h%uint64(numShards)]++
+	}
+
+	return NewDistributionReport(counts), nil
+}
+
+// unseed binds seed to seeded hashers, other values are returned as is.
+func unseed(fn interface{}, seed uint64) interface{} {
+	switch fn := fn.(type) {
+	case func(string, uint64) uint32:
+		return func(k string) uint32 { return fn(k, seed) }
+	case func(string, uint64) uint64:
+		return func(k string) uint64 { return fn(k, seed) }
+	case func(interface{}, uint64) uint32:
+		return func(k interface{}) uint32 { return fn(k, seed) }
+	case func(interface{}, uint64) uint64:
+		return func(k interface{}) uint64 { return fn(k, seed) }
+	default:
+		return fn
+	}
+}
+
+func hasherFunc(fn interface{}) (func(k interface{}) (uint64, error), error) {
+	switch fn := fn.(type) {
+	case func(interface{}) uint32:
+		return func(k interface{}) (uint64, error) { return uint64(fn(k)), nil }, nil
+	case func(interface{}) uint64:
+		return func(k interface{}) (uint64, error) { return fn(k), nil }, nil
+	case func(string) uint32:
+		return func(k interface{}) (uint64, error) {
+			s, ok := k.(string)
+			if !ok {
+				return 0, keyTypeError(k, "string")
+			}
+			return uint64(fn(s)), nil
+		}, nil
+	case func(string) uint64:
+		return func(k interface{}) (uint64, error) {
+			s, ok := k.(string)
+			if !ok {
+				return 0, keyTypeError(k, "string")
+			}
+			return fn(s), nil
+		}, nil
+	case func(uint32) uint32:
+		return func(k interface{}) (uint64, error) {
+			u, ok := k.(uint32)
+			if !ok {
+				return 0, keyTypeError(k, "uint32")
+			}
+			return uint64(fn(u)), nil
+		}, nil
+	case func(uint64) uint32:
+		return func(k interface{}) (uint64, error) {
+			u, ok := k.(uint64)
+			if !ok {
+				return 0, keyTypeError(k, "uint64")
+			}
+			return uint64(fn(u)), nil
+		}, nil
+	case func(uint64) uint64:
+		return func(k interface{}) (uint64, error) {
+			u, ok := k.(uint64)
+			if !ok {
+				return 0, keyTypeError(k, "uint64")
+			}
+			return fn(u), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported hasher type: %T", fn)
+	}
+}
+
+func keyTypeError(k interface{}, want string) error {
+	return fmt.Errorf("hasher expects %s keys, got %T", want, k)
+}
@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/OneOfOne/cmap/hashers"
+)
+
+func TestDistributionReport(t *testing.T) {
+	r := NewDistributionReport([]int{0, 0, 0, 0})
+	if r.Entries != 0 || r.EmptyShards != 4 || math.IsNaN(r.Mean) || math.IsNaN(r.ChiSquare) {
+		t.Fatalf("unexpected empty report: %v", r)
+	}
+
+	r = NewDistributionReport([]int{2, 4, 4, 4, 5, 5, 7, 9})
+	if r.Entries != 40 || r.Min != 2 || r.Max != 9 || r.Mean != 5 || r.StdDev != 2 || r.EmptyShards != 0 {
+		t.Fatalf("unexpected report: %v", r)
+	}
+	if r.ChiSquare != 6.4 {
+		t.Fatalf("expected chi2 of 6.4, got %v", r.ChiSquare)
+	}
+
+	var n int
+	for _, b := range r.Histogram {
+		n += b.Shards
+	}
+	if n != r.Shards {
+		t.Fatalf("histogram covers %d shards, expected %d: %+v", n, r.Shards, r.Histogram)
+	}
+}
+
+func TestHasherReport(t *testing.T) {
+	keys := make([]interface{}, 1e5)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%08d", i)
+	}
+
+	for name, fn := range map[string]interface{}{
+		"Fnv32":        hashers.Fnv32,
+		"Fnv64":        hashers.Fnv64,
+		"TypeHasher32": hashers.TypeHasher32,
+		"TypeHasher64": hashers.TypeHasher64,
+
+		"WyHash32Seed":     hashers.WyHash32Seed,
+		"WyHash64Seed":     hashers.WyHash64Seed,
+		"TypeHasher32Seed": hashers.TypeHasher32Seed,
+		"TypeHasher64Seed": hashers.TypeHasher64Seed,
+	} {
+		r, err := HasherReport(keys, fn, 256)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r.Entries != len(keys) || r.EmptyShards != 0 {
+			t.Fatalf("%s: unexpected report: %v", name, r)
+		}
+		t.Logf("%s: %v", name, r)
+	}
+
+	if _, err := HasherReport(keys, hashers.Mix32, 256); err == nil {
+		t.Fatal("expected a key type error")
+	}
+	if _, err := HasherReport(keys, func(int) int { return 0 }, 256); err == nil {
+		t.Fatal("expected an unsupported hasher error")
+	}
+}
+
+func TestHasherReportSeed(t *testing.T) {
+	keys := make([]interface{}, 1e4)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%08d", i)
+	}
+
+	a, err := HasherReportSeed(keys, hashers.WyHash64Seed, 1, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := HasherReportSeed(keys, hashers.WyHash64Seed, 2, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Entries != len(keys) || b.Entries != len(keys) {
+		t.Fatalf("unexpected reports: %v, %v", a, b)
+	}
+	if a.ChiSquare == b.ChiSquare {
+		t.Fatalf("expected the seed to change the distribution: %v, %v", a, b)
+	}
+
+	if _, err := HasherReportSeed(keys, hashers.WyHash64Seed, 0, 256); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HasherReportSeed(keys, func(int, uint64) uint64 { return 0 }, 1, 256); err == nil {
+		t.Fatal("expected an unsupported hasher error")
+	}
+}
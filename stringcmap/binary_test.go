@@ -0,0 +1,84 @@
+package stringcmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"testing"
+
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+func TestGob(t *testing.T) {
+	lm := NewLMap()
+	for i := 0; i < 100; i++ {
+		lm.Set(strconv.Itoa(i), i)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(lm); err != nil {
+		t.Fatal(err)
+	}
+
+	nlm := NewLMap()
+	nlm.Set("stale", 1)
+	if err := gob.NewDecoder(&buf).Decode(nlm); err != nil {
+		t.Fatal(err)
+	}
+	if nlm.Len() != 100 || nlm.Has("stale") || nlm.Get("42") != 42 {
+		t.Fatalf("unexpected map: %d keys", nlm.Len())
+	}
+}
+
+func TestGobNil(t *testing.T) {
+	lm := NewLMap()
+	lm.Set("a", nil)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(lm); err != nil {
+		t.Fatal(err)
+	}
+	nlm := NewLMap()
+	if err := gob.NewDecoder(&buf).Decode(nlm); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := nlm.GetOK("a"); !ok || v != nil {
+		t.Fatalf("unexpected map: %v", nlm.Keys(nil))
+	}
+}
+
+func TestSnapshotStream(t *testing.T) {
+	cm := NewSize(8)
+	for i := 0; i < 1000; i++ {
+		cm.Set("session-"+strconv.Itoa(i), "token-"+strconv.Itoa(i))
+	}
+
+	opts := &snapshot.StreamOptions{Compression: snapshot.CompressGzip, Key: bytes.Repeat([]byte{1}, 32)}
+
+	var buf bytes.Buffer
+	sw, err := snapshot.NewStreamWriter(&buf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cm.SaveTo(sw); err != nil {
+		t.Fatal(err)
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := snapshot.NewStreamReader(bytes.NewReader(buf.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ncm, err := LoadFrom(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ncm.NumShards() != 8 || ncm.Len() != 1000 || ncm.Get("session-42") != "token-42" {
+		t.Fatalf("unexpected map: %d shards, %d keys", ncm.NumShards(), ncm.Len())
+	}
+}
@@ -0,0 +1,73 @@
+package stringcmap
+
+import "github.com/OneOfOne/cmap/hashers"
+
+// shardForBytes is shardFor for a []byte key, it only converts the key to a string if hot keys are tracked.
+func (cm *CMap) shardForBytes(key []byte) *LMap {
+	h := hashers.WyHash64SeedBytes(key, cm.seed)
+	idx := int(h & uint64(len(cm.shards)-1))
+	if ht := cm.hotTracker(); ht != nil {
+		ht.record(idx, h, string(key))
+	}
+	return cm.shards[idx]
+}
+
+// GetBytes is the equivalent of `val := map[string(key)]`, it doesn't allocate.
+func (cm *CMap) GetBytes(key []byte) (val interface{}) {
+	return cm.shardForBytes(key).GetBytes(key)
+}
+
+// GetOKBytes is the equivalent of `val, ok := map[string(key)]`, it doesn't allocate.
+func (cm *CMap) GetOKBytes(key []byte) (val interface{}, ok bool) {
+	return cm.shardForBytes(key).GetOKBytes(key)
+}
+
+// HasBytes is the equivalent of `_, ok := map[string(key)]`, it doesn't allocate.
+func (cm *CMap) HasBytes(key []byte) bool {
+	return cm.shardForBytes(key).HasBytes(key)
+}
+
+// UpdateBytes is Update for a []byte key, it only converts the key to a string when the key is inserted.
+// If the map has watchers, hooks or a changelog, it falls back to Update and always converts the key.
+func (cm *CMap) UpdateBytes(key []byte, fn func(oldval interface{}) (newval interface{})) {
+	if cm.observers() != nil {
+		cm.Update(string(key), fn)
+		return
+	}
+	cm.shardForBytes(key).UpdateBytes(key, fn)
+}
+
+// GetBytes is the equivalent of `val := map[string(key)]`, it doesn't allocate.
+func (lm *LMap) GetBytes(key []byte) (v interface{}) {
+	lm.rlock()
+	v = lm.m[string(key)]
+	lm.l.RUnlock()
+	return
+}
+
+// GetOKBytes is the equivalent of `val, ok := map[string(key)]`, it doesn't allocate.
+func (lm *LMap) GetOKBytes(key []byte) (v interface{}, ok bool) {
+	lm.rlock()
+	v, ok = lm.m[string(key)]
+	lm.l.RUnlock()
+	return
+}
+
+// HasBytes is the equivalent of `_, ok := map[string(key)]`, it doesn't allocate.
+func (lm *LMap) HasBytes(key []byte) (ok bool) {
+	lm.rlock()
+	_, ok = lm.m[string(key)]
+	lm.l.RUnlock()
+	return
+}
+
+// UpdateBytes is Update for a []byte key, it only converts the key to a string when the key is inserted.
+func (lm *LMap) UpdateBytes(key []byte, fn func(oldVal interface{}) (newVal interface{})) {
+	lm.lock()
+	if p := valueSlot(lm.m, key); p != nil {
+		*p = fn(*p)
+	} else {
+		lm.m[string(key)] = fn(nil)
+	}
+	lm.l.Unlock()
+}
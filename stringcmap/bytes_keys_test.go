@@ -0,0 +1,70 @@
+package stringcmap
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBytesKeys(t *testing.T) {
+	cm := New()
+	for i := 0; i < 1000; i++ {
+		cm.Set("key-"+strconv.Itoa(i), i)
+	}
+
+	for i := 0; i < 1000; i++ {
+		k := "key-" + strconv.Itoa(i)
+		if cm.ShardForKey(k) != cm.shardForBytes([]byte(k)) {
+			t.Fatalf("%s: the []byte hasher doesn't match the string hasher", k)
+		}
+	}
+
+	b := []byte("key-42-and-some-more-bytes-to-be-longer-than-32")
+	cm.Set(string(b), 1)
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		if cm.GetBytes(b) != 1 || !cm.HasBytes(b) || cm.HasBytes(b[:3]) {
+			t.Fatal("unexpected lookup result")
+		}
+		if v, ok := cm.GetOKBytes(b[:6]); !ok || v != 42 {
+			t.Fatal("unexpected GetOKBytes result")
+		}
+	}); allocs != 0 {
+		t.Fatalf("expected 0 allocations, got %v", allocs)
+	}
+
+	var one interface{} = 1
+	same := func(old interface{}) interface{} { return old }
+	if allocs := testing.AllocsPerRun(100, func() {
+		cm.UpdateBytes(b, same)
+		if cm.GetBytes(b) != one {
+			t.Fatal("unexpected GetBytes result")
+		}
+	}); allocs != 0 {
+		t.Fatalf("expected 0 allocations updating an existing key, got %v", allocs)
+	}
+
+	cm.UpdateBytes([]byte("new"), func(old interface{}) interface{} { return "x" })
+	cm.UpdateBytes(b, func(old interface{}) interface{} { return old.(int) + 1 })
+	if cm.Get("new") != "x" || cm.Get(string(b)) != 2 {
+		t.Fatal("UpdateBytes didn't update the map")
+	}
+
+	var events int
+	cm.OnSet(func(ev Event) { events++ }, HookLocked)
+	cm.UpdateBytes(b, func(old interface{}) interface{} { return 3 })
+	if events != 1 || cm.GetBytes(b) != 3 {
+		t.Fatal("UpdateBytes should notify hooks")
+	}
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	cm := New()
+	key := []byte("some/long/network/key/that/is/parsed/from/a/request")
+	cm.Set(string(key), 1)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cm.GetBytes(key)
+		}
+	})
+}
@@ -7,19 +7,321 @@
 package stringcmap
 
 import (
+	"bytes"
+	"container/heap"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/OneOfOne/cmap/hashers"
+	"github.com/OneOfOne/cmap/snapshot"
+	"github.com/OneOfOne/cmap/stats"
 )
 
+// SetBatch sets all the key/value pairs in kvs, it groups them by shard and locks every shard once,
+// later pairs win if kvs has duplicate keys.
+// If there are watchers, hooks or a changelog, the pairs are set one at a time so every mutation is observed.
+func (cm *CMap) SetBatch(kvs []KV) {
+	if ob := cm.observers(); ob != nil {
+		for i := range kvs {
+			cm.Set(kvs[i].Key, kvs[i].Value)
+		}
+		return
+	}
+
+	var (
+		idx    = make([]int, len(kvs))
+		starts = make([]int, len(cm.shards)+1)
+	)
+
+	for i := range kvs {
+		idx[i] = cm.shardIndex(kvs[i].Key)
+		starts[idx[i]+1]++
+	}
+
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+
+	// counting sort, keeps the original order of the pairs inside every shard.
+	order, pos := make([]int, len(kvs)), append([]int(nil), starts[:len(cm.shards)]...)
+	for i, si := range idx {
+		order[pos[si]] = i
+		pos[si]++
+	}
+
+	for si, lm := range cm.shards {
+		start, end := starts[si], starts[si+1]
+		if start == end {
+			continue
+		}
+
+		lm.lock()
+		for _, i := range order[start:end] {
+			lm.m[kvs[i].Key] = kvs[i].Value
+		}
+		lm.l.Unlock()
+	}
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the snapshot format, see SaveTo.
+func (cm *CMap) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the contents and the number of shards of the map
+// with the snapshot in p.
+// Hot key tracking and lock profiling stay enabled, the hot key stats and lock waits are reset.
+// It doesn't notify watchers, hooks or the changelog, and it isn't safe to call while the map is being used.
+func (cm *CMap) UnmarshalBinary(p []byte) error {
+	ncm, err := LoadFrom(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+
+	var lp *lockProfile
+	if len(cm.shards) > 0 {
+		lp = cm.shards[0].lockProfile()
+	}
+
+	cm.shards, cm.clock, cm.seed = ncm.shards, ncm.clock, ncm.seed
+	if cm.keysPool.New == nil { // zero value CMap, ex. allocated by encoding/gob
+		cm.keysPool.New = ncm.keysPool.New
+	}
+
+	// both are sized for the old number of shards
+	if ht := cm.hotTracker(); ht != nil {
+		cm.hot.Store(newHotTracker(len(cm.shards), ht.cfg))
+	}
+	if lp != nil {
+		cm.EnableLockProfiling(lp.name)
+	}
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder, see MarshalBinary.
+func (cm *CMap) GobEncode() ([]byte, error) { return cm.MarshalBinary() }
+
+// GobDecode implements gob.GobDecoder, see UnmarshalBinary.
+func (cm *CMap) GobDecode(p []byte) error { return cm.UnmarshalBinary(p) }
+
+// MarshalBinary implements encoding.BinaryMarshaler using the snapshot format with a single shard.
+func (lm *LMap) MarshalBinary() ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		k   string
+		v   interface{}
+	)
+
+	sw, err := snapshot.NewWriter(&buf, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v), 1, lm.Len())
+	if err != nil {
+		return nil, err
+	}
+	if err = sw.WriteShard(0, lm.snapshot); err != nil {
+		return nil, err
+	}
+	if err = sw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the contents of the map with the snapshot in p.
+// Snapshots of a CMap are accepted too, all their shards are merged.
+func (lm *LMap) UnmarshalBinary(p []byte) error {
+	sr, err := snapshot.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+
+	m := make(map[string]interface{})
+	add := func(k, v interface{}) error {
+		key, val, err := decodeEntry(k, v)
+		if err != nil {
+			return err
+		}
+		m[key] = val
+		return nil
+	}
+
+	for {
+		if _, err = sr.ReadShard(add); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if lm.l == nil { // zero value LMap, ex. allocated by encoding/gob
+		lm.l, lm.clock = new(sync.RWMutex), newClock()
+	}
+
+	lm.lock()
+	lm.m = m
+	lm.l.Unlock()
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder, see MarshalBinary.
+func (lm *LMap) GobEncode() ([]byte, error) { return lm.MarshalBinary() }
+
+// GobDecode implements gob.GobDecoder, see UnmarshalBinary.
+func (lm *LMap) GobDecode(p []byte) error { return lm.UnmarshalBinary(p) }
+
+// ErrNoChangelog is returned by ChangesSince if the changelog isn't enabled.
+var ErrNoChangelog = errors.New("cmap: changelog is not enabled")
+
+// ChangesTruncatedError is returned by ChangesSince when some of the requested changes were already
+// overwritten, the consumer needs to resync from a full copy of the map.
+type ChangesTruncatedError struct {
+	Since  uint64
+	Oldest uint64
+}
+
+func (e *ChangesTruncatedError) Error() string {
+	return fmt.Sprintf("cmap: changes since %d were truncated, the oldest available change is %d", e.Since, e.Oldest)
+}
+
+// ChangesAheadError is returned by ChangesSince for a sequence number newer than the last change,
+// ex. one from before the map was recreated.
+type ChangesAheadError struct {
+	Since uint64
+	Last  uint64
+}
+
+func (e *ChangesAheadError) Error() string {
+	return fmt.Sprintf("cmap: changes since %d were requested, the last change is %d", e.Since, e.Last)
+}
+
+// Change is a logged Event with its sequence number.
+type Change struct {
+	Seq uint64
+	Event
+}
+
+// EnableChangelog attaches an in-memory changelog that keeps the last size changes.
+// Calling it again, or after DisableChangelog, replaces the changelog but the sequence numbers continue from the
+// last change, so ChangesSince returns a *ChangesTruncatedError for the changes that weren't kept.
+// Sequence numbers are assigned while the shard is locked, so the order of the changes of a key always matches
+// the order they were applied in.
+// Note that all the mutations go through a single lock while the changelog is enabled.
+func (cm *CMap) EnableChangelog(size int) {
+	if size < 1 {
+		size = 1
+	}
+	cm.updateObservers(func(ob *observers) {
+		start := atomic.LoadUint64(&cm.changeSeq) + 1
+		ob.changelog = &changelog{entries: make([]Change, size), seq: &cm.changeSeq, start: start, next: start}
+	})
+}
+
+// DisableChangelog detaches and releases the changelog.
+func (cm *CMap) DisableChangelog() {
+	cm.updateObservers(func(ob *observers) { ob.changelog = nil })
+}
+
+// LastSeq returns the sequence number of the last change, or 0 if the changelog is empty or isn't enabled.
+func (cm *CMap) LastSeq() uint64 {
+	ob := cm.observers()
+	if ob == nil || ob.changelog == nil {
+		return 0
+	}
+	cl := ob.changelog
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	return cl.next - 1
+}
+
+// ChangesSince returns all the changes with a sequence number higher than seq, ChangesSince(0) returns everything.
+// It returns a *ChangesTruncatedError if some of them are no longer available, including the changes that were
+// logged by a changelog replaced by EnableChangelog, and a *ChangesAheadError if seq is newer than the last change.
+func (cm *CMap) ChangesSince(seq uint64) ([]Change, error) {
+	ob := cm.observers()
+	if ob == nil || ob.changelog == nil {
+		return nil, ErrNoChangelog
+	}
+	return ob.changelog.since(seq)
+}
+
+type changelog struct {
+	mux     sync.Mutex
+	entries []Change
+	seq     *uint64 // the last sequence number, shared by all the changelogs of the map
+	start   uint64  // the first sequence number of this changelog
+	next    uint64
+}
+
+func (cl *changelog) append(ev *Event) {
+	cl.mux.Lock()
+	// a changelog that was just replaced may still take a number, that change is missing from this one.
+	seq := atomic.AddUint64(cl.seq, 1)
+	cl.entries[seq%uint64(len(cl.entries))] = Change{Seq: seq, Event: *ev}
+	cl.next = seq + 1
+	cl.mux.Unlock()
+}
+
+func (cl *changelog) since(seq uint64) ([]Change, error) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	n := uint64(len(cl.entries))
+	oldest := cl.start
+	if cl.next-cl.start > n {
+		oldest = cl.next - n
+	}
+
+	if seq+1 < oldest {
+		return nil, &ChangesTruncatedError{Since: seq, Oldest: oldest}
+	}
+
+	if seq >= cl.next {
+		return nil, &ChangesAheadError{Since: seq, Last: cl.next - 1}
+	}
+
+	out := make([]Change, 0, cl.next-seq-1)
+	for s := seq + 1; s < cl.next; s++ {
+		c := cl.entries[s%n]
+		if c.Seq != s {
+			// the number was taken by the changelog this one replaced, the changes can't be replayed past it.
+			oldest, out = s+1, out[:0]
+			continue
+		}
+		out = append(out, c)
+	}
+
+	if seq+1 < oldest {
+		return nil, &ChangesTruncatedError{Since: seq, Oldest: oldest}
+	}
+	return out, nil
+}
+
 // DefaultShardCount is the default number of shards to use when New() or NewFromJSON() are called. The default is 256.
 const DefaultShardCount = 1 << 8
 
 // CMap is a concurrent safe sharded map to scale on multiple cores.
 type CMap struct {
-	shards   []*LMap
-	keysPool sync.Pool
+	changeSeq uint64 // the last changelog sequence number, first for 64-bit atomic alignment, see changelog.go
+	shards    []*LMap
+	clock     *uint64 // the current generation, see Checkpoint
+	seed      uint64
+	keysPool  sync.Pool
+	hot       atomic.Value // *hotTracker
+	obs       atomic.Value // *observers
+	obsMux    sync.Mutex
+	obsCfg    observers // guarded by obsMux
 }
 
 // New is an alias for NewSize(DefaultShardCount)
@@ -28,7 +330,14 @@ func New() *CMap { return NewSize(DefaultShardCount) }
 // NewSize returns a CMap with the specific shardSize, note that for performance reasons,
 // shardCount must be a power of 2.
 // Higher shardCount will improve concurrency but will consume more memory.
-func NewSize(shardCount int) *CMap {
+// Keys are hashed with a random seed, so keys chosen by an attacker can't be forced into the same shard.
+func NewSize(shardCount int) *CMap { return NewSizeSeed(shardCount, hashers.NewSeed()) }
+
+// NewSizeSeed is like NewSize but uses a fixed hash seed, ex. to get the same shard distribution in tests.
+// The interface{} keys of cmap.CMap are hashed with hashers.MapHash64, which also mixes in a random per-process
+// seed, so there the same seed only gives the same distribution within a process, and ApplyIncremental returns
+// ErrHashMismatch for a snapshot saved by another process.
+func NewSizeSeed(shardCount int, seed uint64) *CMap {
 	// must be a power of 2
 	if shardCount < 1 {
 		shardCount = DefaultShardCount
@@ -36,8 +345,20 @@ func NewSize(shardCount int) *CMap {
 		panic("shardCount must be a power of 2")
 	}
 
+	shards := make([]*LMap, shardCount)
+	for i := range shards {
+		shards[i] = NewLMapSize(shardCount)
+	}
+
+	return newCMap(shards, seed)
+}
+
+// newCMap returns a CMap made of the shards, their number must be a power of 2.
+func newCMap(shards []*LMap, seed uint64) *CMap {
 	cm := &CMap{
-		shards: make([]*LMap, shardCount),
+		shards: shards,
+		clock:  newClock(),
+		seed:   seed,
 	}
 
 	cm.keysPool.New = func() interface{} {
@@ -46,8 +367,8 @@ func NewSize(shardCount int) *CMap {
 		return &out // return a ptr to avoid extra allocation on Get/Put
 	}
 
-	for i := range cm.shards {
-		cm.shards[i] = NewLMapSize(shardCount)
+	for _, lm := range cm.shards {
+		lm.clock = cm.clock
 	}
 
 	return cm
@@ -55,64 +376,108 @@ func NewSize(shardCount int) *CMap {
 
 // ShardForKey returns the LMap that may hold the specific key.
 func (cm *CMap) ShardForKey(key string) *LMap {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)]
+	return cm.shards[cm.Hash(key)&uint64(len(cm.shards)-1)]
+}
+
+// Hash returns the seeded 64-bit hash the map uses for the key, the key's shard is `Hash(key) & (NumShards()-1)`.
+// For cmap.CMap it is only stable within a process, see NewSizeSeed.
+func (cm *CMap) Hash(key string) uint64 {
+	return hasher(key, cm.seed)
+}
+
+// shardFor returns the LMap that may hold the specific key and records the access if hot keys are tracked.
+func (cm *CMap) shardFor(key string) *LMap {
+	return cm.shards[cm.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard that may hold the specific key and records the access if hot keys are tracked.
+func (cm *CMap) shardIndex(key string) int {
+	h := hasher(key, cm.seed)
+	idx := int(h & uint64(len(cm.shards)-1))
+	if ht := cm.hotTracker(); ht != nil {
+		ht.record(idx, h, key)
+	}
+	return idx
 }
 
 // Set is the equivalent of `map[key] = val`.
 func (cm *CMap) Set(key string, val interface{}) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Set(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		cm.mutate(lm, ob, EventSet, key, val, nil)
+		return
+	}
+	lm.Set(key, val)
 }
 
 // SetIfNotExists will only assign val to key if it wasn't already set.
 // Use `Update` if you need more logic.
 func (cm *CMap) SetIfNotExists(key string, val interface{}) (set bool) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].SetIfNotExists(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		_, existed := cm.mutate(lm, ob, eventSetIfNotExists, key, val, nil)
+		return !existed
+	}
+	return lm.SetIfNotExists(key, val)
 }
 
 // Get is the equivalent of `val := map[key]`.
 func (cm *CMap) Get(key string) (val interface{}) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Get(key)
+	return cm.shardFor(key).Get(key)
 }
 
 // GetOK is the equivalent of `val, ok := map[key]`.
 func (cm *CMap) GetOK(key string) (val interface{}, ok bool) {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].GetOK(key)
+	return cm.shardFor(key).GetOK(key)
 }
 
 // Has is the equivalent of `_, ok := map[key]`.
 func (cm *CMap) Has(key string) bool {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Has(key)
+	return cm.shardFor(key).Has(key)
 }
 
 // Delete is the equivalent of `delete(map, key)`.
 func (cm *CMap) Delete(key string) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Delete(key)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero interface{}
+		cm.mutate(lm, ob, EventDelete, key, zero, nil)
+		return
+	}
+	lm.Delete(key)
 }
 
 // DeleteAndGet is the equivalent of `oldVal := map[key]; delete(map, key)`.
 func (cm *CMap) DeleteAndGet(key string) interface{} {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].DeleteAndGet(key)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero interface{}
+		old, _ := cm.mutate(lm, ob, EventDelete, key, zero, nil)
+		return old
+	}
+	return lm.DeleteAndGet(key)
 }
 
 // Update calls `fn` with the key's old value (or nil) and assign the returned value to the key.
 // The shard containing the key will be locked, it is NOT safe to call other cmap funcs inside `fn`.
 func (cm *CMap) Update(key string, fn func(oldval interface{}) (newval interface{})) {
-	h := hasher(key)
-	cm.shards[h&uint32(len(cm.shards)-1)].Update(key, fn)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		var zero interface{}
+		cm.mutate(lm, ob, EventUpdate, key, zero, fn)
+		return
+	}
+	lm.Update(key, fn)
 }
 
 // Swap is the equivalent of `oldVal, map[key] = map[key], newVal`.
 func (cm *CMap) Swap(key string, val interface{}) interface{} {
-	h := hasher(key)
-	return cm.shards[h&uint32(len(cm.shards)-1)].Swap(key, val)
+	lm := cm.shardFor(key)
+	if ob := cm.observers(); ob != nil {
+		old, _ := cm.mutate(lm, ob, EventSwap, key, val, nil)
+		return old
+	}
+	return lm.Swap(key, val)
 }
 
 // Keys returns a slice of all the keys of the map.
@@ -165,17 +530,31 @@ func (cm *CMap) Len() int {
 
 // ShardDistribution returns the distribution of data amoung all shards.
 // Useful for debugging the efficiency of a hash.
+// All the values are 0 if the map is empty.
 func (cm *CMap) ShardDistribution() []float64 {
 	var (
 		out = make([]float64, len(cm.shards))
 		ln  = float64(cm.Len())
 	)
+	if ln == 0 {
+		return out
+	}
 	for i := range out {
 		out[i] = float64(cm.shards[i].Len()) / ln
 	}
 	return out
 }
 
+// DistributionReport returns detailed statistics about the distribution of data among all shards.
+// Each shard is read-locked separately, so the report isn't an atomic snapshot of a map that is being modified.
+func (cm *CMap) DistributionReport() *stats.DistributionReport {
+	counts := make([]int, len(cm.shards))
+	for i, lm := range cm.shards {
+		counts[i] = lm.Len()
+	}
+	return stats.NewDistributionReport(counts)
+}
+
 // KV holds the key/value returned when Iter is called.
 type KV struct {
 	Key   string
@@ -224,16 +603,651 @@ func (cm *CMap) iterContext(ctx context.Context, ch chan<- *KV, locked bool) {
 	}
 }
 
+// Seed returns the hash seed of the map, for cmap.CMap it doesn't fix the hashes across processes, see NewSizeSeed.
+func (cm *CMap) Seed() uint64 { return cm.seed }
+
 // NumShards returns the number of shards in the map.
 func (cm *CMap) NumShards() int { return len(cm.shards) }
 
-func hasher(key string) uint32 { return hashers.Fnv32(key) }
+// Shard returns the i-th shard of the map, it panics if i is out of range.
+func (cm *CMap) Shard(i int) *LMap { return cm.shards[i] }
+
+func hasher(key string, seed uint64) uint64 { return hashers.WyHash64Seed(key, seed) }
+
+// EventOp is the kind of mutation described by an Event.
+type EventOp uint8
+
+// The mutations reported to watchers, hooks and the changelog.
+const (
+	EventSet EventOp = iota + 1
+	EventDelete
+	EventUpdate
+	EventSwap
+	EventEvict
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventUpdate:
+		return "update"
+	case EventSwap:
+		return "swap"
+	case EventEvict:
+		return "evict"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a single mutation of a key.
+// Existed reports whether the key was in the map before the mutation, OldValue is only valid if it is true.
+type Event struct {
+	Op       EventOp
+	Key      string
+	OldValue interface{}
+	NewValue interface{}
+	Existed  bool
+}
+
+// eventSetIfNotExists is only used internally by mutate, it is reported as EventSet.
+const eventSetIfNotExists EventOp = 0
+
+// observers holds everything that needs to be notified of mutations, it is replaced, never modified, when
+// something is added or removed.
+type observers struct {
+	watchers  []*watcher
+	changelog *changelog
+	hooks     []*hook
+	onPanic   func(ev Event, v interface{})
+}
+
+func (cm *CMap) observers() *observers {
+	ob, _ := cm.obs.Load().(*observers)
+	return ob
+}
+
+// updateObservers replaces the current observers with a modified copy, fn must not keep a reference to ob.
+// The observers are only published if they have something to notify, so the mutating funcs can use the fast path.
+func (cm *CMap) updateObservers(fn func(ob *observers)) {
+	cm.obsMux.Lock()
+	ob := cm.obsCfg
+	fn(&ob)
+	cm.obsCfg = ob
+	if len(ob.watchers) == 0 && ob.changelog == nil && len(ob.hooks) == 0 {
+		cm.obs.Store((*observers)(nil))
+	} else {
+		cm.obs.Store(&ob)
+	}
+	cm.obsMux.Unlock()
+}
+
+// mutate is the slow path of all the mutating funcs when there are observers attached to the map, ob may be nil.
+// Changes are logged and HookLocked hooks run while the shard is locked,
+// watchers and HookAfterUnlock hooks are notified after it is unlocked.
+func (cm *CMap) mutate(lm *LMap, ob *observers, op EventOp, key string, val interface{}, fn func(interface{}) interface{}) (old interface{}, existed bool) {
+	lm.lock()
+	old, existed = lm.m[key]
+
+	switch op {
+	case eventSetIfNotExists:
+		if existed {
+			lm.l.Unlock()
+			return
+		}
+		op = EventSet
+		lm.m[key] = val
+	case EventSet, EventSwap:
+		lm.m[key] = val
+	case EventUpdate:
+		val = fn(old)
+		lm.m[key] = val
+	case EventDelete, EventEvict:
+		if !existed {
+			lm.l.Unlock()
+			return
+		}
+		delete(lm.m, key)
+	}
+
+	if ob == nil {
+		lm.l.Unlock()
+		return
+	}
+
+	ev := Event{Op: op, Key: key, OldValue: old, NewValue: val, Existed: existed}
+	if ob.changelog != nil {
+		ob.changelog.append(&ev)
+	}
+	ob.runHooks(HookLocked, &ev)
+
+	lm.l.Unlock()
+
+	ob.runHooks(HookAfterUnlock, &ev)
+	for _, w := range ob.watchers {
+		w.notify(&ev)
+	}
+
+	return
+}
+
+// HookMode controls when a hook runs.
+type HookMode uint8
+
+const (
+	// HookAfterUnlock runs the hook synchronously on the mutating goroutine after the shard is unlocked,
+	// hooks of concurrent mutations of the same key may run out of order.
+	HookAfterUnlock HookMode = iota
+	// HookLocked runs the hook while the shard is locked, it is NOT safe to call other cmap funcs inside the hook.
+	HookLocked
+	// HookAsync queues the event after the shard is unlocked and runs the hook on its own goroutine,
+	// in the order the events were queued. The mutating goroutine only blocks if the hook is more than
+	// hookQueueSize events behind. Events that are still queued when the hook is removed are dropped.
+	HookAsync
+)
+
+// hookQueueSize is the number of events queued for a HookAsync hook before the mutations block.
+const hookQueueSize = 1024
+
+// OnSet registers fn to be called after every Set, SetIfNotExists, Update and Swap.
+// The returned func removes the hook.
+func (cm *CMap) OnSet(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventSet, EventUpdate, EventSwap)
+}
+
+// OnDelete registers fn to be called after every Delete and DeleteAndGet that removed a key.
+// The returned func removes the hook.
+func (cm *CMap) OnDelete(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventDelete)
+}
+
+// OnEvict registers fn to be called after every Evict that removed a key.
+// The returned func removes the hook.
+func (cm *CMap) OnEvict(fn func(ev Event), mode HookMode) (remove func()) {
+	return cm.addHook(fn, mode, EventEvict)
+}
+
+// SetHookPanicHandler sets the func called with the event and the recovered value when a hook panics,
+// without one the panic is recovered and dropped silently.
+func (cm *CMap) SetHookPanicHandler(fn func(ev Event, v interface{})) {
+	cm.updateObservers(func(ob *observers) { ob.onPanic = fn })
+}
+
+// Evict removes the key like DeleteAndGet, but it is reported as EventEvict instead of EventDelete.
+// CMap never evicts keys by itself, it is meant for callers implementing expiration or size limits
+// so hooks and watchers can tell evictions and deletes apart.
+func (cm *CMap) Evict(key string) (old interface{}, ok bool) {
+	var zero interface{}
+	return cm.mutate(cm.shardFor(key), cm.observers(), EventEvict, key, zero, nil)
+}
+
+type hook struct {
+	fn   func(ev Event)
+	mode HookMode
+	ops  [EventEvict + 1]bool
+
+	// HookAsync only
+	queue chan Event
+	done  chan struct{}
+}
+
+func (cm *CMap) addHook(fn func(ev Event), mode HookMode, ops ...EventOp) func() {
+	h := &hook{fn: fn, mode: mode}
+	for _, op := range ops {
+		h.ops[op] = true
+	}
+
+	if mode == HookAsync {
+		h.queue, h.done = make(chan Event, hookQueueSize), make(chan struct{})
+		go cm.runAsyncHook(h)
+	}
+
+	cm.updateObservers(func(ob *observers) {
+		ob.hooks = append(ob.hooks[:len(ob.hooks):len(ob.hooks)], h)
+	})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cm.updateObservers(func(ob *observers) {
+				hs := make([]*hook, 0, len(ob.hooks))
+				for _, oh := range ob.hooks {
+					if oh != h {
+						hs = append(hs, oh)
+					}
+				}
+				ob.hooks = hs
+			})
+			if h.done != nil {
+				close(h.done)
+			}
+		})
+	}
+}
+
+func (cm *CMap) runAsyncHook(h *hook) {
+	for {
+		select {
+		case ev := <-h.queue:
+			var onPanic func(ev Event, v interface{})
+			if ob := cm.observers(); ob != nil {
+				onPanic = ob.onPanic
+			}
+			h.run(&ev, onPanic)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// runHooks runs the hooks of the mode, the HookAfterUnlock pass also queues the events of the HookAsync hooks.
+func (ob *observers) runHooks(mode HookMode, ev *Event) {
+	for _, h := range ob.hooks {
+		if !h.ops[ev.Op] {
+			continue
+		}
+		switch {
+		case h.mode == mode:
+			h.run(ev, ob.onPanic)
+		case h.mode == HookAsync && mode == HookAfterUnlock:
+			select {
+			case h.queue <- *ev:
+			case <-h.done:
+			}
+		}
+	}
+}
+
+func (h *hook) run(ev *Event, onPanic func(ev Event, v interface{})) {
+	defer func() {
+		if v := recover(); v != nil {
+			if onPanic != nil {
+				onPanic(*ev, v)
+			}
+		}
+	}()
+	h.fn(*ev)
+}
+
+// HotKeysConfig controls the hot key tracker, zero values are replaced by the defaults.
+type HotKeysConfig struct {
+	// SampleRate is how many accesses per shard are counted for every sampled one, default 16.
+	SampleRate int
+	// TopK is the number of hot keys to keep per shard, default 8.
+	TopK int
+	// SketchWidth and SketchDepth are the dimensions of the per-shard count-min sketch, default 256x4.
+	SketchWidth int
+	SketchDepth int
+}
+
+// HotKey is a key with its estimated number of accesses and the access rate per second.
+type HotKey struct {
+	Key   string
+	Hits  uint64
+	Rate  float64
+	Shard int
+}
+
+// HotShard is a shard with its number of accesses and the access rate per second.
+type HotShard struct {
+	Shard int
+	Hits  uint64
+	Rate  float64
+}
+
+// EnableHotKeys starts tracking key and shard accesses, it resets the tracker if it was already enabled.
+// Passing a nil cfg uses the defaults.
+func (cm *CMap) EnableHotKeys(cfg *HotKeysConfig) {
+	var c HotKeysConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.SampleRate < 1 {
+		c.SampleRate = 16
+	}
+	if c.TopK < 1 {
+		c.TopK = 8
+	}
+	if c.SketchWidth < 1 {
+		c.SketchWidth = 256
+	}
+	if c.SketchDepth < 1 {
+		c.SketchDepth = 4
+	}
+
+	cm.hot.Store(newHotTracker(len(cm.shards), c))
+}
+
+// DisableHotKeys stops tracking accesses and releases the tracker.
+func (cm *CMap) DisableHotKeys() {
+	cm.hot.Store((*hotTracker)(nil))
+}
+
+// HotKeys returns up to n of the most accessed keys, sorted by the number of hits.
+// Returns nil if hot key tracking isn't enabled.
+func (cm *CMap) HotKeys(n int) []HotKey {
+	ht := cm.hotTracker()
+	if ht == nil {
+		return nil
+	}
+
+	secs := time.Since(ht.started).Seconds()
+	out := []HotKey{}
+	for i := range ht.shards {
+		hs := &ht.shards[i]
+		hs.mux.Lock()
+		for _, e := range hs.top.entries {
+			hits := uint64(e.hits) * ht.rate
+			out = append(out, HotKey{Key: e.key, Hits: hits, Rate: float64(hits) / secs, Shard: i})
+		}
+		hs.mux.Unlock()
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// HotShards returns up to n of the most accessed shards, sorted by the number of hits.
+// Returns nil if hot key tracking isn't enabled.
+func (cm *CMap) HotShards(n int) []HotShard {
+	ht := cm.hotTracker()
+	if ht == nil {
+		return nil
+	}
+
+	secs := time.Since(ht.started).Seconds()
+	out := make([]HotShard, len(ht.shards))
+	for i := range ht.shards {
+		hits := atomic.LoadUint64(&ht.shards[i].hits)
+		out[i] = HotShard{Shard: i, Hits: hits, Rate: float64(hits) / secs}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func (cm *CMap) hotTracker() *hotTracker {
+	ht, _ := cm.hot.Load().(*hotTracker)
+	return ht
+}
+
+type hotTracker struct {
+	shards  []hotShard
+	rate    uint64
+	started time.Time
+	cfg     HotKeysConfig
+}
+
+func newHotTracker(shards int, c HotKeysConfig) *hotTracker {
+	ht := &hotTracker{
+		shards:  make([]hotShard, shards),
+		rate:    uint64(c.SampleRate),
+		started: time.Now(),
+		cfg:     c,
+	}
+	for i := range ht.shards {
+		ht.shards[i].sketch = stats.NewCountMin(c.SketchWidth, c.SketchDepth)
+		ht.shards[i].top = hotHeap{k: c.TopK, idx: make(map[string]int, c.TopK)}
+	}
+	return ht
+}
+
+// record counts an access to the shard and samples the key into the shard's sketch.
+func (ht *hotTracker) record(shard int, h uint64, key string) {
+	hs := &ht.shards[shard]
+	if atomic.AddUint64(&hs.hits, 1)%ht.rate != 0 {
+		return
+	}
+
+	hs.mux.Lock()
+	hs.top.offer(key, hs.sketch.Add(h, 1))
+	hs.mux.Unlock()
+}
+
+type hotShard struct {
+	hits uint64 // must be first for atomic alignment on 32bit platforms
+
+	mux    sync.Mutex
+	sketch *stats.CountMin
+	top    hotHeap
+}
+
+type hotEntry struct {
+	key  string
+	hits uint32
+}
+
+// hotHeap is a min-heap of the top k entries, the root is the least accessed one.
+type hotHeap struct {
+	entries []hotEntry
+	idx     map[string]int
+	k       int
+}
+
+func (hh *hotHeap) offer(key string, hits uint32) {
+	if i, ok := hh.idx[key]; ok {
+		hh.entries[i].hits = hits
+		heap.Fix(hh, i)
+		return
+	}
+
+	if len(hh.entries) < hh.k {
+		heap.Push(hh, hotEntry{key, hits})
+		return
+	}
+
+	if hits > hh.entries[0].hits {
+		delete(hh.idx, hh.entries[0].key)
+		hh.entries[0] = hotEntry{key, hits}
+		hh.idx[key] = 0
+		heap.Fix(hh, 0)
+	}
+}
+
+func (hh *hotHeap) Len() int           { return len(hh.entries) }
+func (hh *hotHeap) Less(i, j int) bool { return hh.entries[i].hits < hh.entries[j].hits }
+
+func (hh *hotHeap) Swap(i, j int) {
+	hh.entries[i], hh.entries[j] = hh.entries[j], hh.entries[i]
+	hh.idx[hh.entries[i].key], hh.idx[hh.entries[j].key] = i, j
+}
+
+func (hh *hotHeap) Push(x interface{}) {
+	e := x.(hotEntry)
+	hh.idx[e.key] = len(hh.entries)
+	hh.entries = append(hh.entries, e)
+}
+
+func (hh *hotHeap) Pop() interface{} {
+	e := hh.entries[len(hh.entries)-1]
+	hh.entries = hh.entries[:len(hh.entries)-1]
+	delete(hh.idx, e.key)
+	return e
+}
+
+// Generations are read from a clock shared by all the shards of a CMap, every write lock of a shard stores the
+// current generation in it, and Checkpoint advances the clock, so shards modified after a checkpoint have a
+// generation >= the checkpoint.
+
+func newClock() *uint64 {
+	c := uint64(1)
+	return &c
+}
+
+// touch marks the map as modified in the current generation, it must be called while the map is write-locked.
+func (lm *LMap) touch() {
+	if lm.clock != nil {
+		atomic.StoreUint64(&lm.gen, atomic.LoadUint64(lm.clock))
+	}
+}
+
+// Generation returns the generation of the last modification of the map, see CMap.Checkpoint.
+// It is conservative, operations that lock the map without modifying it, like deleting a missing key, still count.
+func (lm *LMap) Generation() uint64 {
+	return atomic.LoadUint64(&lm.gen)
+}
+
+// Checkpoint advances the generation of the map and returns it, shards modified after Checkpoint returns
+// will be saved by SaveIncremental(w, gen).
+// Usage:
+//
+//	gen := cm.Checkpoint()
+//	cm.SaveTo(base)
+//	gen, _ = cm.SaveIncremental(inc1, gen)
+//	gen, _ = cm.SaveIncremental(inc2, gen)
+func (cm *CMap) Checkpoint() uint64 {
+	return atomic.AddUint64(cm.clock, 1)
+}
+
+// SaveIncremental writes a snapshot of the shards modified since the checkpoint sinceGen, it returns the next
+// checkpoint to pass to the following SaveIncremental.
+// Shards are written whole, so deleted keys are dropped when the snapshot is applied with ApplyIncremental,
+// and SaveIncremental(w, 0) writes every shard.
+// It uses the default codecs for the key and value types, see SaveToWith.
+func (cm *CMap) SaveIncremental(w io.Writer, sinceGen uint64) (next uint64, err error) {
+	var (
+		k string
+		v interface{}
+	)
+	return cm.SaveIncrementalWith(w, sinceGen, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v))
+}
+
+// SaveIncrementalWith is like SaveIncremental but uses the specific codecs.
+func (cm *CMap) SaveIncrementalWith(w io.Writer, sinceGen uint64, kc, vc snapshot.Codec) (next uint64, err error) {
+	next = cm.Checkpoint()
+
+	sw, err := snapshot.NewWriterSeed(w, kc, vc, len(cm.shards), cm.Len(), cm.seed)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, lm := range cm.shards {
+		// the generation is checked under the lock, so a shard is either skipped before a write locks it,
+		// and that write gets a generation >= next, or written with all the writes that happened before.
+		lm.rlock()
+		if lm.Generation() >= sinceGen {
+			err = sw.WriteShard(i, lm.snapshotLocked)
+		}
+		lm.l.RUnlock()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = sw.Close(); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+var (
+	// ErrSeedMismatch is returned when an incremental snapshot was written by a map with a different hash seed.
+	ErrSeedMismatch = errors.New("cmap: the snapshot was written by a map with a different hash seed")
+	// ErrHashMismatch is returned when a key of an incremental snapshot doesn't belong to its shard, because the
+	// snapshot was written with a different hasher, ex. by another process when the hasher is built on hash/maphash.
+	ErrHashMismatch = errors.New("cmap: the snapshot was written with a different hasher")
+)
+
+// ShardCountError is returned when an incremental snapshot doesn't have the same number of shards as the map.
+type ShardCountError struct {
+	Expected, Got int
+}
+
+func (e *ShardCountError) Error() string {
+	return fmt.Sprintf("cmap: expected a snapshot with %d shards, got %d", e.Expected, e.Got)
+}
+
+// ApplyIncremental replaces the shards of the map with the ones in a snapshot written by SaveIncremental,
+// the map must have the same number of shards, hash seed and hasher as the map that wrote it, ex. one returned by
+// Restore or LoadFrom in the same process.
+// Shards can't be replaced when the keys hash differently, since the keys deleted from them can't be told apart,
+// so snapshots written by another process with a hasher built on hash/maphash, like the default hasher of
+// cmap.CMap, return ErrHashMismatch, use Restore for them.
+// Every shard is decoded before it is replaced, so it is applied whole or not at all, however an error may leave
+// the map with only some of the shards of the snapshot applied.
+// It doesn't notify watchers, hooks or the changelog.
+func (cm *CMap) ApplyIncremental(r io.Reader) error {
+	sr, err := incrementalReader(r, len(cm.shards), cm.seed)
+	if err != nil {
+		return err
+	}
+
+	mask := uint64(len(cm.shards) - 1)
+	return readShards(sr, func(idx int, m map[string]interface{}) error {
+		for key := range m {
+			if hasher(key, cm.seed)&mask != uint64(idx) {
+				return ErrHashMismatch
+			}
+		}
+
+		lm := cm.shards[idx]
+		lm.lock()
+		lm.m = m
+		lm.l.Unlock()
+		return nil
+	})
+}
+
+// Restore loads a base snapshot written by SaveTo and applies a chain of incremental snapshots written by the same
+// map on top of it in order.
+// The shards are replaced in the layout of the map that wrote the snapshots, then the keys are put in the shards they
+// hash to, so unlike ApplyIncremental, it restores snapshots written by another process.
+func Restore(base io.Reader, incrementals ...io.Reader) (*CMap, error) {
+	shards, seed, err := readFull(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, r := range incrementals {
+		var sr *snapshot.Reader
+		if sr, err = incrementalReader(r, len(shards), seed); err == nil {
+			err = readShards(sr, func(idx int, m map[string]interface{}) error {
+				shards[idx] = m
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cmap: incremental snapshot %d: %w", i, err)
+		}
+	}
+
+	return fromShards(shards, seed), nil
+}
+
+// incrementalReader reads the header of an incremental snapshot and checks it matches a map with the shard count
+// and seed.
+func incrementalReader(r io.Reader, shards int, seed uint64) (*snapshot.Reader, error) {
+	sr, err := snapshot.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(sr.Shards) != shards {
+		return nil, &ShardCountError{Expected: shards, Got: int(sr.Shards)}
+	}
+	if sr.Seed != seed {
+		return nil, ErrSeedMismatch
+	}
+	return sr, nil
+}
 
 // LMap is a simple sync.RWMutex locked map.
 // Used by CMap internally for sharding.
 type LMap struct {
-	m map[string]interface{}
-	l *sync.RWMutex
+	gen   uint64  // generation of the last modification, see incremental.go
+	clock *uint64 // shared by all the shards of a CMap
+	m     map[string]interface{}
+	l     *sync.RWMutex
+	prof  atomic.Value // *lockProfile
 }
 
 // NewLMap returns a new LMap with the cap set to 0.
@@ -244,14 +1258,15 @@ func NewLMap() *LMap {
 // NewLMapSize is the equivalent of `m := make(map[string]interface{}, cap)`
 func NewLMapSize(cap int) *LMap {
 	return &LMap{
-		m: make(map[string]interface{}, cap),
-		l: new(sync.RWMutex),
+		clock: newClock(),
+		m:     make(map[string]interface{}, cap),
+		l:     new(sync.RWMutex),
 	}
 }
 
 // Set is the equivalent of `map[key] = val`.
 func (lm *LMap) Set(key string, v interface{}) {
-	lm.l.Lock()
+	lm.lock()
 	lm.m[key] = v
 	lm.l.Unlock()
 }
@@ -259,7 +1274,7 @@ func (lm *LMap) Set(key string, v interface{}) {
 // SetIfNotExists will only assign val to key if it wasn't already set.
 // Use `Update` if you need more logic.
 func (lm *LMap) SetIfNotExists(key string, val interface{}) (set bool) {
-	lm.l.Lock()
+	lm.lock()
 	if _, ok := lm.m[key]; !ok {
 		lm.m[key], set = val, true
 	}
@@ -269,7 +1284,7 @@ func (lm *LMap) SetIfNotExists(key string, val interface{}) (set bool) {
 
 // Get is the equivalent of `val := map[key]`.
 func (lm *LMap) Get(key string) (v interface{}) {
-	lm.l.RLock()
+	lm.rlock()
 	v = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -277,7 +1292,7 @@ func (lm *LMap) Get(key string) (v interface{}) {
 
 // GetOK is the equivalent of `val, ok := map[key]`.
 func (lm *LMap) GetOK(key string) (v interface{}, ok bool) {
-	lm.l.RLock()
+	lm.rlock()
 	v, ok = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -285,7 +1300,7 @@ func (lm *LMap) GetOK(key string) (v interface{}, ok bool) {
 
 // Has is the equivalent of `_, ok := map[key]`.
 func (lm *LMap) Has(key string) (ok bool) {
-	lm.l.RLock()
+	lm.rlock()
 	_, ok = lm.m[key]
 	lm.l.RUnlock()
 	return
@@ -293,14 +1308,14 @@ func (lm *LMap) Has(key string) (ok bool) {
 
 // Delete is the equivalent of `delete(map, key)`.
 func (lm *LMap) Delete(key string) {
-	lm.l.Lock()
+	lm.lock()
 	delete(lm.m, key)
 	lm.l.Unlock()
 }
 
 // DeleteAndGet is the equivalent of `oldVal := map[key]; delete(map, key)`.
 func (lm *LMap) DeleteAndGet(key string) (v interface{}) {
-	lm.l.Lock()
+	lm.lock()
 	v = lm.m[key]
 	delete(lm.m, key)
 	lm.l.Unlock()
@@ -310,14 +1325,14 @@ func (lm *LMap) DeleteAndGet(key string) (v interface{}) {
 // Update calls `fn` with the key's old value (or nil) and assigns the returned value to the key.
 // The shard containing the key will be locked, it is NOT safe to call other cmap funcs inside `fn`.
 func (lm *LMap) Update(key string, fn func(oldVal interface{}) (newVal interface{})) {
-	lm.l.Lock()
+	lm.lock()
 	lm.m[key] = fn(lm.m[key])
 	lm.l.Unlock()
 }
 
 // Swap is the equivalent of `oldVal, map[key] = map[key], newVal`.
 func (lm *LMap) Swap(key string, newV interface{}) (oldV interface{}) {
-	lm.l.Lock()
+	lm.lock()
 	oldV = lm.m[key]
 	lm.m[key] = newV
 	lm.l.Unlock()
@@ -328,14 +1343,14 @@ func (lm *LMap) Swap(key string, newV interface{}) (oldV interface{}) {
 // You can break early by returning an error .
 // It **is** safe to modify the map while using this iterator, however it uses more memory and is slightly slower.
 func (lm *LMap) ForEach(keys []string, fn func(key string, val interface{}) bool) bool {
-	lm.l.RLock()
+	lm.rlock()
 	for key := range lm.m {
 		keys = append(keys, key)
 	}
 	lm.l.RUnlock()
 
 	for _, key := range keys {
-		lm.l.RLock()
+		lm.rlock()
 		val, ok := lm.m[key]
 		lm.l.RUnlock()
 		if !ok {
@@ -353,7 +1368,7 @@ func (lm *LMap) ForEach(keys []string, fn func(key string, val interface{}) bool
 // You can break early by returning false
 // It is **NOT* safe to modify the map while using this iterator.
 func (lm *LMap) ForEachLocked(fn func(key string, val interface{}) bool) bool {
-	lm.l.RLock()
+	lm.rlock()
 	defer lm.l.RUnlock()
 
 	for key, val := range lm.m {
@@ -367,7 +1382,7 @@ func (lm *LMap) ForEachLocked(fn func(key string, val interface{}) bool) bool {
 
 // Len returns the length of the map.
 func (lm *LMap) Len() (ln int) {
-	lm.l.RLock()
+	lm.rlock()
 	ln = len(lm.m)
 	lm.l.RUnlock()
 	return
@@ -376,7 +1391,7 @@ func (lm *LMap) Len() (ln int) {
 // Keys appends all the keys in the map to buf and returns buf.
 // buf may be nil.
 func (lm *LMap) Keys(buf []string) []string {
-	lm.l.RLock()
+	lm.rlock()
 	if cap(buf) == 0 {
 		buf = make([]string, 0, len(lm.m))
 	}
@@ -386,3 +1401,488 @@ func (lm *LMap) Keys(buf []string) []string {
 	lm.l.RUnlock()
 	return buf
 }
+
+// EnableLockProfiling instruments the shard locks to record how long callers wait for them.
+// name identifies the map in the trace regions and pprof labels, if it's empty the map's address is used.
+// Waits are recorded in the histogram of every shard, see LMap.LockWaits, and contended waits run inside a
+// runtime/trace region named after the map and shard.
+// The runtime's mutex profile can't be split by map: it ignores goroutine labels and the shard locks don't set
+// any. For per-map and per-shard numbers, use LockWaits and the trace regions.
+func (cm *CMap) EnableLockProfiling(name string) {
+	if name == "" {
+		name = fmt.Sprintf("%p", cm)
+	}
+	for i, lm := range cm.shards {
+		lm.EnableLockProfiling(name, i)
+	}
+}
+
+// DisableLockProfiling removes the lock instrumentation from all the shards.
+func (cm *CMap) DisableLockProfiling() {
+	for _, lm := range cm.shards {
+		lm.DisableLockProfiling()
+	}
+}
+
+// LockWaits returns the merged lock wait histogram of all the shards.
+func (cm *CMap) LockWaits() (s stats.DurationSnapshot) {
+	for _, lm := range cm.shards {
+		ss := lm.LockWaits()
+		s.Merge(&ss)
+	}
+	return
+}
+
+// LabelContext returns ctx with the "cmap" and "shard" pprof labels of the shard that may hold key. The labels
+// only apply to the callers that use it, and only show in the CPU and goroutine profiles, not the mutex profile, ex.
+//
+//	pprof.Do(cm.LabelContext(ctx, key), pprof.Labels(), func(context.Context) { cm.Update(key, fn) })
+//
+// ctx is returned as is if lock profiling isn't enabled.
+func (cm *CMap) LabelContext(ctx context.Context, key string) context.Context {
+	return cm.ShardForKey(key).LabelContext(ctx)
+}
+
+// EnableLockProfiling instruments the map's lock to record how long callers wait for it.
+// See CMap.EnableLockProfiling.
+func (lm *LMap) EnableLockProfiling(name string, shard int) {
+	ss := strconv.Itoa(shard)
+	lm.prof.Store(&lockProfile{
+		name:   name,
+		labels: pprof.Labels("cmap", name, "shard", ss),
+		region: "cmap:" + name + ":shard:" + ss,
+	})
+}
+
+// LabelContext returns ctx with the "cmap" and "shard" pprof labels of the map, see CMap.LabelContext.
+func (lm *LMap) LabelContext(ctx context.Context) context.Context {
+	if lp := lm.lockProfile(); lp != nil {
+		return pprof.WithLabels(ctx, lp.labels)
+	}
+	return ctx
+}
+
+// DisableLockProfiling removes the lock instrumentation.
+func (lm *LMap) DisableLockProfiling() {
+	lm.prof.Store((*lockProfile)(nil))
+}
+
+// LockWaits returns a snapshot of the lock wait histogram, it is empty if lock profiling isn't enabled.
+func (lm *LMap) LockWaits() stats.DurationSnapshot {
+	if lp := lm.lockProfile(); lp != nil {
+		return lp.waits.Snapshot()
+	}
+	return stats.DurationSnapshot{}
+}
+
+func (lm *LMap) lockProfile() *lockProfile {
+	lp, _ := lm.prof.Load().(*lockProfile)
+	return lp
+}
+
+// lock write-locks the map and marks it as dirty, see Generation.
+func (lm *LMap) lock() {
+	if lp := lm.lockProfile(); lp != nil {
+		lp.lock(lm.l, false)
+	} else {
+		lm.l.Lock()
+	}
+	lm.touch()
+}
+
+func (lm *LMap) rlock() {
+	if lp := lm.lockProfile(); lp != nil {
+		lp.lock(lm.l, true)
+		return
+	}
+	lm.l.RLock()
+}
+
+type lockProfile struct {
+	waits  stats.DurationHistogram
+	name   string
+	labels pprof.LabelSet
+	region string
+}
+
+func (lp *lockProfile) lock(l *sync.RWMutex, read bool) {
+	if read && l.TryRLock() || !read && l.TryLock() {
+		lp.waits.Observe(0)
+		return
+	}
+
+	start := time.Now()
+	r := trace.StartRegion(context.Background(), lp.region)
+	if read {
+		l.RLock()
+	} else {
+		l.Lock()
+	}
+	r.End()
+	lp.waits.Observe(time.Since(start))
+}
+
+// SaveTo writes a binary snapshot of the map to w using the default codecs for the key and value types,
+// see SaveToWith.
+func (cm *CMap) SaveTo(w io.Writer) error {
+	var (
+		k string
+		v interface{}
+	)
+	return cm.SaveToWith(w, snapshot.DefaultCodec(k), snapshot.DefaultCodec(v))
+}
+
+// SaveToWith writes a binary snapshot of the map to w using the specific codecs.
+// Shards are encoded one at a time while read-locked, so writers are never blocked on more than one shard
+// or on w, however the snapshot isn't an atomic copy of a map that is being modified.
+func (cm *CMap) SaveToWith(w io.Writer, kc, vc snapshot.Codec) error {
+	sw, err := snapshot.NewWriterSeed(w, kc, vc, len(cm.shards), cm.Len(), cm.seed)
+	if err != nil {
+		return err
+	}
+
+	for i, lm := range cm.shards {
+		if err = sw.WriteShard(i, lm.snapshot); err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// LoadFrom reads a snapshot written by SaveTo or SaveToWith and returns a new CMap with the same number of shards
+// and hash seed, version 1 snapshots get a random seed.
+// The codecs are looked up by the ids in the snapshot header.
+// Keys are put in the shards they hash to, so snapshots written by another process or with another hasher load too.
+func LoadFrom(r io.Reader) (*CMap, error) {
+	shards, seed, err := readFull(r)
+	if err != nil {
+		return nil, err
+	}
+	return fromShards(shards, seed), nil
+}
+
+// readFull reads a snapshot that has the sections of all the shards in order, like SaveTo writes them, and returns
+// the entries of every shard and the hash seed.
+// The shards are allocated as their sections are read, so the shard count in the header can't allocate more than
+// the input justifies.
+func readFull(r io.Reader) (shards []map[string]interface{}, seed uint64, err error) {
+	sr, err := snapshot.NewReader(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = readShards(sr, func(idx int, m map[string]interface{}) error {
+		if idx != len(shards) {
+			return snapshot.ErrCorrupt
+		}
+		shards = append(shards, m)
+		return nil
+	})
+	if err == nil && len(shards) != int(sr.Shards) {
+		err = snapshot.ErrCorrupt
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if seed = sr.Seed; sr.Version < 2 {
+		seed = hashers.NewSeed()
+	}
+	return shards, seed, nil
+}
+
+// readShards decodes the shard sections of a snapshot one at a time and calls fn with every shard.
+func readShards(sr *snapshot.Reader, fn func(idx int, m map[string]interface{}) error) error {
+	for {
+		m := make(map[string]interface{})
+		idx, err := sr.ReadShard(func(k, v interface{}) error {
+			key, val, err := decodeEntry(k, v)
+			if err != nil {
+				return err
+			}
+			m[key] = val
+			return nil
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(idx, m); err != nil {
+			return err
+		}
+	}
+}
+
+// fromShards returns a CMap made of the shards of a snapshot, the keys that hash to another shard are moved to it,
+// ex. the snapshot was written by another process and the hasher is built on hash/maphash.
+func fromShards(ms []map[string]interface{}, seed uint64) *CMap {
+	var (
+		shards = make([]*LMap, len(ms))
+		mask   = uint64(len(ms) - 1)
+		moved  []KV
+	)
+	for i, m := range ms {
+		for k, v := range m {
+			if hasher(k, seed)&mask != uint64(i) {
+				moved = append(moved, KV{k, v})
+				delete(m, k)
+			}
+		}
+		shards[i] = &LMap{m: m, l: new(sync.RWMutex)}
+	}
+
+	for _, kv := range moved {
+		shards[hasher(kv.Key, seed)&mask].m[kv.Key] = kv.Value
+	}
+	return newCMap(shards, seed)
+}
+
+// decodeEntry converts a key and value read from a snapshot to the types of the map.
+// nil is accepted, it is how a nil interface is decoded and the codecs never decode it for other types.
+func decodeEntry(k, v interface{}) (key string, val interface{}, err error) {
+	var ok bool
+	if key, ok = k.(string); !ok && k != nil {
+		return key, val, &snapshot.TypeError{Codec: "key", Value: k, Decode: true}
+	}
+	if val, ok = v.(interface{}); !ok && v != nil {
+		return key, val, &snapshot.TypeError{Codec: "value", Value: v, Decode: true}
+	}
+	return key, val, nil
+}
+
+// snapshot encodes all the entries of the map while it is read-locked.
+func (lm *LMap) snapshot(add func(key, val interface{}) error) error {
+	lm.rlock()
+	defer lm.l.RUnlock()
+	return lm.snapshotLocked(add)
+}
+
+// snapshotLocked encodes all the entries of the map, the caller must hold the lock.
+func (lm *LMap) snapshotLocked(add func(key, val interface{}) error) error {
+	for k, v := range lm.m {
+		if err := add(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchPolicy controls what happens when a watcher can't keep up with the events.
+type WatchPolicy uint8
+
+const (
+	// WatchDrop drops the events that don't fit in the watcher's buffer.
+	WatchDrop WatchPolicy = iota
+	// WatchBlock blocks the mutating caller until the event is received or the watch context is done.
+	WatchBlock
+	// WatchCoalesce keeps one pending event per key, merging the new event into it,
+	// the merged event has the old value of the first event and the new value of the last one.
+	// A delete or evict of a key that didn't exist before the pending event cancels it, nothing is sent.
+	WatchCoalesce
+)
+
+// DefaultWatchBuffer is the channel buffer size used when WatchFilter.Buffer is 0.
+const DefaultWatchBuffer = 64
+
+// WatchFilter selects the events sent to a watcher.
+// If both Keys and Match are empty, all the events are sent.
+type WatchFilter struct {
+	// Keys is a list of exact keys to watch.
+	Keys []string
+	// Match is called for keys that aren't in Keys, the event is sent if it returns true.
+	Match func(key string) bool
+
+	Policy WatchPolicy
+	Buffer int
+}
+
+// Watch returns a channel that receives an Event after every mutation of a key matching filter.
+// Events are sent after the shard is unlocked, so events of concurrent mutations of the same key may be
+// received out of order.
+// Only mutations done through the CMap are reported, not the ones done directly on a shard's LMap.
+// The channel is closed after ctx is done.
+func (cm *CMap) Watch(ctx context.Context, filter *WatchFilter) <-chan Event {
+	var f WatchFilter
+	if filter != nil {
+		f = *filter
+	}
+	if f.Buffer < 1 {
+		f.Buffer = DefaultWatchBuffer
+	}
+
+	w := &watcher{
+		ctx:    ctx,
+		ch:     make(chan Event, f.Buffer),
+		match:  f.Match,
+		policy: f.Policy,
+	}
+
+	if len(f.Keys) > 0 {
+		w.keys = make(map[string]struct{}, len(f.Keys))
+		for _, k := range f.Keys {
+			w.keys[k] = struct{}{}
+		}
+	}
+
+	if w.policy == WatchCoalesce {
+		w.pending = make(map[string]*Event)
+		w.wake = make(chan struct{}, 1)
+		go w.deliver()
+	}
+
+	cm.updateObservers(func(ob *observers) {
+		ob.watchers = append(ob.watchers[:len(ob.watchers):len(ob.watchers)], w)
+	})
+
+	go func() {
+		<-ctx.Done()
+		cm.updateObservers(func(ob *observers) {
+			ws := make([]*watcher, 0, len(ob.watchers))
+			for _, ow := range ob.watchers {
+				if ow != w {
+					ws = append(ws, ow)
+				}
+			}
+			ob.watchers = ws
+		})
+		w.close()
+	}()
+
+	return w.ch
+}
+
+type watcher struct {
+	ctx    context.Context
+	ch     chan Event
+	keys   map[string]struct{}
+	match  func(key string) bool
+	policy WatchPolicy
+
+	mux    sync.RWMutex
+	closed bool
+
+	// used by WatchCoalesce
+	pending map[string]*Event
+	order   []string
+	wake    chan struct{}
+}
+
+func (w *watcher) matches(key string) bool {
+	if w.keys == nil && w.match == nil {
+		return true
+	}
+	if _, ok := w.keys[key]; ok {
+		return true
+	}
+	return w.match != nil && w.match(key)
+}
+
+func (w *watcher) notify(ev *Event) {
+	if !w.matches(ev.Key) {
+		return
+	}
+
+	if w.policy == WatchCoalesce {
+		w.coalesce(ev)
+		return
+	}
+
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.closed {
+		return
+	}
+
+	if w.policy == WatchBlock {
+		select {
+		case w.ch <- *ev:
+		case <-w.ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case w.ch <- *ev:
+	default:
+	}
+}
+
+func (w *watcher) coalesce(ev *Event) {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return
+	}
+
+	if p, ok := w.pending[ev.Key]; ok {
+		if !p.Existed && (ev.Op == EventDelete || ev.Op == EventEvict) {
+			w.drop(ev.Key)
+		} else {
+			p.Op, p.NewValue = ev.Op, ev.NewValue
+		}
+	} else {
+		e := *ev
+		w.pending[ev.Key] = &e
+		w.order = append(w.order, ev.Key)
+	}
+	w.mux.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop removes the pending event of key, w.mux must be held.
+func (w *watcher) drop(key string) {
+	delete(w.pending, key)
+	for i, k := range w.order {
+		if k == key {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// deliver sends the coalesced events in the order their keys were first modified.
+func (w *watcher) deliver() {
+	defer close(w.ch)
+	for {
+		select {
+		case <-w.wake:
+		case <-w.ctx.Done():
+			return
+		}
+
+		for {
+			w.mux.Lock()
+			if len(w.order) == 0 {
+				w.mux.Unlock()
+				break
+			}
+			key := w.order[0]
+			w.order = w.order[1:]
+			ev := w.pending[key]
+			delete(w.pending, key)
+			w.mux.Unlock()
+
+			select {
+			case w.ch <- *ev:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.mux.Lock()
+	w.closed = true
+	if w.policy != WatchCoalesce { // deliver owns the channel
+		close(w.ch)
+	}
+	w.mux.Unlock()
+}
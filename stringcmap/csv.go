@@ -0,0 +1,130 @@
+package stringcmap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/OneOfOne/cmap/internal/jsonw"
+)
+
+// CSVOptions controls ExportCSV and ImportCSV, every record is a key and a value field.
+type CSVOptions struct {
+	// Comma is the field delimiter, defaults to ','.
+	Comma rune
+
+	// Header writes a "key,value" header on export, and skips the first record on import.
+	Header bool
+
+	// EncodeValue encodes the values on export, by default strings are written as is and other values as json.
+	EncodeValue func(key string, val interface{}) (string, error)
+
+	// DecodeValue decodes the values on import, by default the values are set as strings.
+	DecodeValue func(key, field string) (interface{}, error)
+}
+
+var defaultCSVOptions CSVOptions
+
+func (o *CSVOptions) encodeValue(key string, val interface{}) (string, error) {
+	if o.EncodeValue != nil {
+		return o.EncodeValue(key, val)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(val)
+	return string(b), err
+}
+
+// ExportCSV writes every key/value pair to w as a csv record.
+// It stops at the first encode or write error and returns it with the number of bytes written to w.
+func (cm *CMap) ExportCSV(w io.Writer, opts *CSVOptions) (n int64, err error) {
+	if opts == nil {
+		opts = &defaultCSVOptions
+	}
+
+	cnt := jsonw.NewCountingWriter(w)
+	cw := csv.NewWriter(cnt)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+
+	if opts.Header {
+		err = cw.Write([]string{"key", "value"})
+	}
+
+	if err == nil {
+		var rec [2]string
+		cm.ForEach(func(key string, val interface{}) bool {
+			rec[0] = key
+			if rec[1], err = opts.encodeValue(key, val); err != nil {
+				err = &KeyError{Key: key, Err: err}
+				return false
+			}
+			err = cw.Write(rec[:])
+			return err == nil
+		})
+	}
+
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+
+	return cnt.Count(), err
+}
+
+// ImportCSV reads key/value csv records from r and sets them in batches.
+// It returns the number of imported records, the records before a bad line are imported and
+// parse and decode errors are returned as a *LineError.
+func (cm *CMap) ImportCSV(r io.Reader, opts *CSVOptions) (n int, err error) {
+	if opts == nil {
+		opts = &defaultCSVOptions
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.ReuseRecord = true
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+
+	batch := make([]KV, 0, importBatchSize)
+	flush := func() {
+		cm.SetBatch(batch)
+		n += len(batch)
+		batch = batch[:0]
+	}
+	defer flush()
+
+	for skip := opts.Header; ; skip = false {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return n, &LineError{Line: pe.StartLine, Err: pe.Err}
+		} else if err != nil {
+			return n, err
+		}
+
+		if skip {
+			continue
+		}
+
+		var v interface{} = rec[1]
+		if opts.DecodeValue != nil {
+			if v, err = opts.DecodeValue(rec[0], rec[1]); err != nil {
+				line, _ := cr.FieldPos(0)
+				return n, &LineError{Line: line, Err: &KeyError{Key: rec[0], Err: err}}
+			}
+		}
+
+		if batch = append(batch, KV{rec[0], v}); len(batch) == cap(batch) {
+			flush()
+		}
+	}
+}
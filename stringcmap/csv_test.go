@@ -0,0 +1,60 @@
+package stringcmap
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	cm := New()
+	for i := 0; i < 3000; i++ {
+		cm.Set("k,"+strconv.Itoa(i), i)
+	}
+
+	opts := &CSVOptions{
+		Comma:  ';',
+		Header: true,
+		DecodeValue: func(key, field string) (interface{}, error) {
+			return strconv.Atoi(field)
+		},
+	}
+
+	var buf bytes.Buffer
+	n, err := cm.ExportCSV(&buf, opts)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("n = %d, len = %d, err = %v", n, buf.Len(), err)
+	}
+	if !strings.HasPrefix(buf.String(), "key;value\n") {
+		t.Fatalf("missing header: %.20q", buf.String())
+	}
+
+	ncm := New()
+	if n, err := ncm.ImportCSV(&buf, opts); err != nil || n != 3000 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	cm.ForEach(func(key string, val interface{}) bool {
+		if nv := ncm.Get(key); nv != val {
+			t.Fatalf("%s: expected %v, got %v", key, val, nv)
+		}
+		return true
+	})
+}
+
+func TestCSVImportErrors(t *testing.T) {
+	cm := New()
+	n, err := cm.ImportCSV(strings.NewReader("a,1\nb,2\nc\n"), nil)
+	var le *LineError
+	if !errors.As(err, &le) || le.Line != 3 || n != 2 || cm.Get("b") != "2" {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+
+	opts := &CSVOptions{DecodeValue: func(key, field string) (interface{}, error) { return strconv.Atoi(field) }}
+	n, err = New().ImportCSV(strings.NewReader("a,1\n\"b\nb\",x\n"), opts)
+	var ke *KeyError
+	if !errors.As(err, &le) || le.Line != 2 || !errors.As(err, &ke) || ke.Key != "b\nb" || n != 1 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+}
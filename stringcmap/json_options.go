@@ -0,0 +1,118 @@
+package stringcmap
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"sort"
+)
+
+// WriteOptions controls the output of MapWithJSON.WriteTo, the zero value matches json.Marshal.
+type WriteOptions struct {
+	// SortKeys outputs the keys in sorted order, the sorted keys of every shard are merged while writing,
+	// so only the keys are held in memory, not the values.
+	SortKeys bool
+
+	// Prefix and Indent work like json.MarshalIndent, the output isn't indented if both are empty.
+	Prefix string
+	Indent string
+
+	// DisableHTMLEscape disables escaping <, > and & in keys and values, see json.Encoder.SetEscapeHTML.
+	DisableHTMLEscape bool
+
+	// MarshalValueFn is used instead of json.Marshal to encode the values if it is set.
+	MarshalValueFn func(key string, val interface{}) ([]byte, error)
+}
+
+var defaultWriteOptions WriteOptions
+
+func (o *WriteOptions) indented() bool { return o.Prefix != "" || o.Indent != "" }
+
+func (o *WriteOptions) marshalKey(key string) ([]byte, error) {
+	if !o.DisableHTMLEscape {
+		return json.Marshal(key)
+	}
+	return marshalNoEscape(key)
+}
+
+func (o *WriteOptions) marshalValue(key string, val interface{}) (vj []byte, err error) {
+	switch {
+	case o.MarshalValueFn != nil:
+		vj, err = o.MarshalValueFn(key, val)
+	case o.DisableHTMLEscape:
+		vj, err = marshalNoEscape(val)
+	default:
+		vj, err = json.Marshal(val)
+	}
+
+	if err != nil || !o.indented() {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = json.Indent(&buf, vj, o.Prefix+o.Indent, o.Indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalNoEscape(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// forEachSorted calls fn for every key in sorted order, merging the sorted keys of every shard.
+// Keys deleted after their shard was listed are skipped.
+func (cm *CMap) forEachSorted(fn func(key string, val interface{}) bool) bool {
+	ks := make(shardKeys, 0, len(cm.shards))
+	for _, lm := range cm.shards {
+		keys := lm.Keys(nil)
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+		ks = append(ks, &sortedShard{lm: lm, keys: keys})
+	}
+
+	heap.Init(&ks)
+	for len(ks) > 0 {
+		ss := ks[0]
+		key := ss.keys[0]
+		if ss.keys = ss.keys[1:]; len(ss.keys) == 0 {
+			heap.Pop(&ks)
+		} else {
+			heap.Fix(&ks, 0)
+		}
+
+		if val, ok := ss.lm.GetOK(key); ok && !fn(key, val) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type sortedShard struct {
+	lm   *LMap
+	keys []string
+}
+
+// shardKeys is a min-heap of shards ordered by their smallest remaining key.
+type shardKeys []*sortedShard
+
+func (sk shardKeys) Len() int            { return len(sk) }
+func (sk shardKeys) Less(i, j int) bool  { return sk[i].keys[0] < sk[j].keys[0] }
+func (sk shardKeys) Swap(i, j int)       { sk[i], sk[j] = sk[j], sk[i] }
+func (sk *shardKeys) Push(x interface{}) { *sk = append(*sk, x.(*sortedShard)) }
+
+func (sk *shardKeys) Pop() interface{} {
+	old := *sk
+	ss := old[len(old)-1]
+	*sk = old[:len(old)-1]
+	return ss
+}
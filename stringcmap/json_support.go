@@ -1,10 +1,13 @@
 package stringcmap
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"sync"
+
+	"github.com/OneOfOne/cmap/internal/jsonw"
 )
 
 // WithJSON returns a MapWithJSON with the specific unmarshal func.
@@ -22,99 +25,256 @@ func (cm *CMap) WithJSON(fn func(raw json.RawMessage) (interface{}, error)) *Map
 type MapWithJSON struct {
 	*CMap
 	UnmarshalValueFn func(raw json.RawMessage) (interface{}, error)
+
+	// Workers is the number of goroutines ReadFrom uses to decode values, values are decoded in order if it is < 2.
+	// Either way, when a key appears more than once the last value wins.
+	Workers int
+
+	// Options controls the output of WriteTo and MarshalJSON, nil uses the defaults.
+	Options *WriteOptions
 }
 
-// WriteTo implements io.WriterTo, outputs the map as a json object.
+// WriteTo implements io.WriterTo, outputs the map as a json object formatted according to Options.
+// It stops at the first marshal or write error and returns it with the number of bytes written to w.
 func (mwj *MapWithJSON) WriteTo(w io.Writer) (n int64, err error) {
-	var buf writerWithBytes
-	switch w := w.(type) {
-	case writerWithBytes:
-		buf = w
-	default:
-		buf = bufio.NewWriter(w)
+	opts := mwj.Options
+	if opts == nil {
+		opts = &defaultWriteOptions
+	}
+
+	var sep, nl string
+	if sep = ":"; opts.indented() {
+		sep, nl = ": ", "\n"+opts.Prefix+opts.Indent
 	}
 
-	_ = buf.WriteByte('{')
+	e := jsonw.NewEncoder(w)
+	e.PutByte('{')
 
-	mwj.ForEach(func(key string, val interface{}) bool {
-		var vj []byte
-		if vj, err = json.Marshal(val); err != nil {
-			return false
+	first := true
+	fn := func(key string, val interface{}) bool {
+		kj, err := opts.marshalKey(key)
+		if err != nil {
+			return e.Fail(err)
 		}
-		if n > 0 {
-			_ = buf.WriteByte(',')
+		vj, err := opts.marshalValue(key, val)
+		if err != nil {
+			return e.Fail(err)
 		}
-		kj, _ := json.Marshal(key)
-		kn, _ := buf.Write(kj)
-		_ = buf.WriteByte(':')
-		vn, _ := buf.Write(vj)
-		n += int64(kn + vn + 1)
-		return true
-	})
 
-	if err != nil {
-		n = 0
-		return
+		if !first {
+			e.PutByte(',')
+		}
+		first = false
+
+		e.Put([]byte(nl))
+		e.Put(kj)
+		e.Put([]byte(sep))
+		return e.Put(vj)
 	}
 
-	_ = buf.WriteByte('}')
-	n += 2 // {}
+	if e.Err() == nil {
+		if opts.SortKeys {
+			mwj.forEachSorted(fn)
+		} else {
+			mwj.ForEach(fn)
+		}
+	}
 
-	if buf, ok := buf.(flusher); ok {
-		err = buf.Flush()
+	if !first && nl != "" {
+		e.Put([]byte("\n" + opts.Prefix))
 	}
+	e.PutByte('}')
 
-	return
+	return e.Close()
 }
 
-// UnmarshalJSON implements json.Unmarshaler
+// UnmarshalJSON implements json.Unmarshaler.
 func (mwj *MapWithJSON) UnmarshalJSON(p []byte) error {
-	if mwj.UnmarshalValueFn != nil {
-		return mwj.unmarshalJSONTyped(p)
-	}
-
-	return mwj.unmarshalJSON(p)
+	_, err := mwj.ReadFrom(bytes.NewReader(p))
+	return err
 }
 
-func (mwj *MapWithJSON) unmarshalJSON(p []byte) error {
-	var in map[string]interface{}
-	if err := json.Unmarshal(p, &in); err != nil {
-		return err
+// ReadFrom implements io.ReaderFrom, it streams a json object from r into the map one key at a time,
+// without decoding the whole object in memory first.
+// r must only hold the object, anything but whitespace after it is an error.
+// Values are decoded with UnmarshalValueFn if it is set, and by Workers goroutines if it is > 1.
+// Errors decoding a value are returned as a *KeyError.
+func (mwj *MapWithJSON) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countingReader{r: r}
+	dec := json.NewDecoder(cr)
+
+	defer func() { n = cr.n }()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return
+	}
+	if tok == nil { // null
+		return n, checkEOF(dec)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return n, fmt.Errorf("stringcmap: expected a json object, got %v", tok)
 	}
 
-	if len(in) > 0 && mwj.CMap == nil {
+	if mwj.CMap == nil {
 		mwj.CMap = New()
 	}
 
-	for k, v := range in {
-		mwj.Set(k, v)
+	var vd *valueDecoder
+	if mwj.Workers > 1 {
+		vd = newValueDecoder(mwj, mwj.Workers)
+		defer func() {
+			if verr := vd.close(); err == nil {
+				err = verr
+			}
+		}()
 	}
 
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return
+		}
+		key := tok.(string)
+
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return n, &KeyError{Key: key, Err: err}
+		}
+
+		if vd != nil {
+			if err = vd.decode(key, raw); err != nil {
+				return
+			}
+			continue
+		}
+
+		if err = mwj.setRaw(key, raw); err != nil {
+			return
+		}
+	}
+
+	if _, err = dec.Token(); err != nil { // }
+		return
+	}
+	return n, checkEOF(dec)
+}
+
+// checkEOF returns an error if dec has anything but whitespace left after the json value.
+func checkEOF(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	switch {
+	case err == io.EOF:
+		return nil
+	case err == nil:
+		return fmt.Errorf("stringcmap: unexpected %v after the json object", tok)
+	}
+	return fmt.Errorf("stringcmap: unexpected data after the json object: %v", err)
+}
+
+func (mwj *MapWithJSON) setRaw(key string, raw json.RawMessage) error {
+	v, err := mwj.unmarshalValue(raw)
+	if err != nil {
+		return &KeyError{Key: key, Err: err}
+	}
+	mwj.Set(key, v)
 	return nil
 }
 
-func (mwj *MapWithJSON) unmarshalJSONTyped(p []byte) error {
-	var in map[string]json.RawMessage
-	if err := json.Unmarshal(p, &in); err != nil {
-		return err
+func (mwj *MapWithJSON) unmarshalValue(raw json.RawMessage) (v interface{}, err error) {
+	if mwj.UnmarshalValueFn != nil {
+		return mwj.UnmarshalValueFn(raw)
 	}
+	err = json.Unmarshal(raw, &v)
+	return
+}
 
-	if len(in) > 0 && mwj.CMap == nil {
-		mwj.CMap = New()
+// KeyError is returned when decoding the value of a key fails.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("stringcmap: error decoding key %q: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// valueDecoder decodes values with a bounded number of goroutines,
+// a key always goes to the same goroutine so repeated keys are set in input order.
+type valueDecoder struct {
+	mwj  *MapWithJSON
+	jobs []chan rawKV
+	wg   sync.WaitGroup
+
+	mux sync.Mutex
+	err error
+}
+
+type rawKV struct {
+	key string
+	raw json.RawMessage
+}
+
+func newValueDecoder(mwj *MapWithJSON, workers int) *valueDecoder {
+	vd := &valueDecoder{mwj: mwj, jobs: make([]chan rawKV, workers)}
+	vd.wg.Add(workers)
+	for i := range vd.jobs {
+		vd.jobs[i] = make(chan rawKV, 1)
+		go vd.work(vd.jobs[i])
 	}
+	return vd
+}
 
-	for k, rj := range in {
-		v, err := mwj.UnmarshalValueFn(rj)
-		if err != nil {
-			return err
+func (vd *valueDecoder) work(jobs <-chan rawKV) {
+	defer vd.wg.Done()
+	for kv := range jobs {
+		if vd.firstErr() != nil {
+			continue
+		}
+		if err := vd.mwj.setRaw(kv.key, kv.raw); err != nil {
+			vd.mux.Lock()
+			if vd.err == nil {
+				vd.err = err
+			}
+			vd.mux.Unlock()
 		}
-
-		mwj.Set(k, v)
 	}
+}
 
+// decode queues a value, it returns the first error of the workers so the caller can stop early.
+func (vd *valueDecoder) decode(key string, raw json.RawMessage) error {
+	if err := vd.firstErr(); err != nil {
+		return err
+	}
+	vd.jobs[hasher(key, 0)%uint64(len(vd.jobs))] <- rawKV{key, raw}
 	return nil
 }
 
+func (vd *valueDecoder) firstErr() error {
+	vd.mux.Lock()
+	defer vd.mux.Unlock()
+	return vd.err
+}
+
+func (vd *valueDecoder) close() error {
+	for _, ch := range vd.jobs {
+		close(ch)
+	}
+	vd.wg.Wait()
+	return vd.err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (mwj *MapWithJSON) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := mwj.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // MarshalJSON implements json.Marshaler.
 func (cm *CMap) MarshalJSON() ([]byte, error) {
 	var buf bytes.Buffer
@@ -124,11 +284,13 @@ func (cm *CMap) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-type writerWithBytes interface {
-	io.Writer
-	io.ByteWriter
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-type flusher interface {
-	Flush() error
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return
 }
@@ -1,11 +1,15 @@
 package stringcmap
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +67,210 @@ func TestJSONType(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONReadFrom(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `"%d":%d`, i, i)
+	}
+	buf.WriteByte('}')
+	ln := int64(buf.Len())
+
+	for _, workers := range []int{0, 4} {
+		mwj := MapWithJSON{Workers: workers}
+		mwj.UnmarshalValueFn = func(j json.RawMessage) (interface{}, error) {
+			return strconv.Atoi(string(j))
+		}
+
+		n, err := mwj.ReadFrom(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != ln || mwj.Len() != 1000 || mwj.Get("999") != 999 {
+			t.Fatalf("workers %d: unexpected result: n = %d, len = %d", workers, n, mwj.Len())
+		}
+	}
+}
+
+func TestJSONReadFromDuplicateKeys(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `"%d":%d`, i%10, i)
+	}
+	buf.WriteByte('}')
+
+	for _, workers := range []int{0, 4} {
+		mwj := MapWithJSON{Workers: workers}
+		if _, err := mwj.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		for i := 990; i < 1000; i++ {
+			if v := mwj.Get(strconv.Itoa(i % 10)); v != float64(i) {
+				t.Fatalf("workers %d: expected the last value %d for %d, got %v", workers, i, i%10, v)
+			}
+		}
+	}
+}
+
+func TestJSONReadFromErrors(t *testing.T) {
+	mwj := New().WithJSON(func(j json.RawMessage) (interface{}, error) {
+		return strconv.Atoi(string(j))
+	})
+
+	for _, workers := range []int{0, 4} {
+		mwj.Workers = workers
+		_, err := mwj.ReadFrom(strings.NewReader(`{"a":1,"b":"x","c":3}`))
+		if ke, ok := err.(*KeyError); !ok || ke.Key != "b" {
+			t.Fatalf("workers %d: expected a *KeyError for b, got %v", workers, err)
+		}
+	}
+
+	if _, err := mwj.ReadFrom(strings.NewReader(`[1, 2]`)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	var empty MapWithJSON
+	if _, err := empty.ReadFrom(strings.NewReader("null \n")); err != nil || empty.CMap != nil {
+		t.Fatalf("unexpected result: %v", err)
+	}
+
+	for _, in := range []string{`{"a":1} garbage`, `{"a":1} {"b":2}`, `{"a":1} 1`, `null x`} {
+		if _, err := mwj.ReadFrom(strings.NewReader(in)); err == nil {
+			t.Fatalf("%s: expected an error for the trailing data", in)
+		}
+	}
+}
+
+func TestJSONWriteOptions(t *testing.T) {
+	m := map[string]interface{}{}
+	mwj := MapWithJSON{CMap: New()}
+	for i := 0; i < 500; i++ {
+		k, v := "k<"+strconv.Itoa(i), map[string]interface{}{"v": "&" + strconv.Itoa(i), "n": []int{i, i}}
+		m[k] = v
+		mwj.Set(k, v)
+	}
+
+	exp, _ := json.Marshal(m)
+	mwj.Options = &WriteOptions{SortKeys: true}
+	if got, err := json.Marshal(&mwj); err != nil || !bytes.Equal(got, exp) {
+		t.Fatalf("sorted output mismatch: %v\n%s\n%s", err, got, exp)
+	}
+
+	exp, _ = json.MarshalIndent(m, ">", "\t")
+	mwj.Options = &WriteOptions{SortKeys: true, Prefix: ">", Indent: "\t"}
+	var buf bytes.Buffer
+	if _, err := mwj.WriteTo(&buf); err != nil || !bytes.Equal(buf.Bytes(), exp) {
+		t.Fatalf("indented output mismatch: %v\n%s\n%s", err, buf.Bytes(), exp)
+	}
+
+	mwj.Options = &WriteOptions{SortKeys: true, DisableHTMLEscape: true}
+	got, _ := mwj.MarshalJSON()
+	if bytes.Contains(got, []byte(`\u003c`)) || bytes.Contains(got, []byte(`\u0026`)) || !bytes.Contains(got, []byte(`"k<1":`)) {
+		t.Fatalf("html escaped output: %.100s", got)
+	}
+
+	var empty MapWithJSON
+	empty.CMap = New()
+	empty.Options = &WriteOptions{SortKeys: true, Indent: "  "}
+	if got, _ := empty.MarshalJSON(); string(got) != "{}" {
+		t.Fatalf("expected {}, got %s", got)
+	}
+}
+
+func TestJSONMarshalValueFn(t *testing.T) {
+	mwj := MapWithJSON{CMap: New()}
+	mwj.Set("a", 1)
+	mwj.Set("b", 2)
+	mwj.Options = &WriteOptions{
+		SortKeys: true,
+		MarshalValueFn: func(key string, val interface{}) ([]byte, error) {
+			return []byte(strconv.Quote(key + strconv.Itoa(val.(int)))), nil
+		},
+	}
+
+	if got, _ := mwj.MarshalJSON(); string(got) != `{"a":"a1","b":"b2"}` {
+		t.Fatalf("unexpected output %s", got)
+	}
+
+	mwj.Options.MarshalValueFn = func(string, interface{}) ([]byte, error) { return nil, fmt.Errorf("nope") }
+	if _, err := mwj.MarshalJSON(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+type limitWriter struct {
+	n     int
+	calls int
+}
+
+var errLimit = fmt.Errorf("limit reached")
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	lw.calls++
+	if len(p) > lw.n {
+		n := lw.n
+		lw.n = 0
+		return n, errLimit
+	}
+	lw.n -= len(p)
+	return len(p), nil
+}
+
+func TestJSONWriteToCount(t *testing.T) {
+	mwj := MapWithJSON{CMap: New()}
+	for i := 0; i < 1000; i++ {
+		mwj.Set(strconv.Itoa(i), strings.Repeat("x", i%50))
+	}
+
+	for _, opts := range []*WriteOptions{nil, {SortKeys: true}, {Indent: "  "}} {
+		mwj.Options = opts
+		var buf bytes.Buffer
+		n, err := mwj.WriteTo(&buf)
+		if err != nil || n != int64(buf.Len()) {
+			t.Fatalf("%+v: n = %d, len = %d, err = %v", opts, n, buf.Len(), err)
+		}
+
+		var sb strings.Builder // unbuffered path
+		if n, err = mwj.WriteTo(struct{ io.Writer }{&sb}); err != nil || n != int64(sb.Len()) || sb.Len() != buf.Len() {
+			t.Fatalf("%+v: n = %d, len = %d, err = %v", opts, n, sb.Len(), err)
+		}
+	}
+}
+
+func TestJSONWriteToError(t *testing.T) {
+	mwj := MapWithJSON{CMap: New()}
+	for i := 0; i < 10000; i++ {
+		mwj.Set(strconv.Itoa(i), strings.Repeat("x", 100))
+	}
+
+	var marshaled int
+	mwj.Options = &WriteOptions{
+		MarshalValueFn: func(_ string, val interface{}) ([]byte, error) {
+			marshaled++
+			return json.Marshal(val)
+		},
+	}
+
+	lw := &limitWriter{n: 5000}
+	n, err := mwj.WriteTo(lw)
+	if err != errLimit {
+		t.Fatalf("expected errLimit, got %v", err)
+	}
+	if n != 5000 {
+		t.Fatalf("expected 5000 bytes, got %d", n)
+	}
+	if lw.calls != 2 {
+		t.Fatalf("expected the writer to be called twice, got %d", lw.calls)
+	}
+	if marshaled > 100 {
+		t.Fatalf("iteration didn't stop, marshaled %d values", marshaled)
+	}
+}
@@ -0,0 +1,24 @@
+package stringcmap
+
+import "unsafe"
+
+// mapaccess2_faststr is the runtime's lookup for maps with string keys, it is one of the runtime functions
+// that are kept stable for linkname, see go.dev/issue/67401.
+//
+//go:linkname mapaccess2_faststr runtime.mapaccess2_faststr
+func mapaccess2_faststr(t, m unsafe.Pointer, key string) (unsafe.Pointer, bool)
+
+// valueSlot returns a pointer to the value of key in m, or nil if the key isn't set.
+// Assigning through the pointer keeps the stored key, unlike m[string(key)] = v, so it doesn't allocate.
+// The pointer is only valid until the next change to m, the caller must hold the lock.
+func valueSlot(m map[string]interface{}, key []byte) *interface{} {
+	// an interface{} is a (type, data) pair, the data of a map is its *hmap.
+	var mi interface{} = m
+	e := (*[2]unsafe.Pointer)(unsafe.Pointer(&mi))
+	// the key is only used for the lookup, so it can share key's memory.
+	p, ok := mapaccess2_faststr(e[0], e[1], *(*string)(unsafe.Pointer(&key)))
+	if !ok {
+		return nil
+	}
+	return (*interface{})(p)
+}
@@ -0,0 +1,135 @@
+package stringcmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/OneOfOne/cmap/internal/jsonw"
+)
+
+// importBatchSize is the number of records Import* collects before inserting them with SetBatch.
+const importBatchSize = 1024
+
+// LineError is returned when importing a record fails, Line starts at 1.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("stringcmap: line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LineError) Unwrap() error { return e.Err }
+
+type ndjsonRecord struct {
+	K *string         `json:"k"`
+	V json.RawMessage `json:"v"`
+}
+
+// ExportNDJSON writes every key/value pair to w as a `{"k":key,"v":value}` line.
+// It stops at the first marshal or write error and returns it with the number of bytes written to w.
+func (mwj *MapWithJSON) ExportNDJSON(w io.Writer) (n int64, err error) {
+	e := jsonw.NewEncoder(w)
+
+	mwj.ForEach(func(key string, val interface{}) bool {
+		kj, err := json.Marshal(key)
+		if err != nil {
+			return e.Fail(err)
+		}
+		vj, err := json.Marshal(val)
+		if err != nil {
+			return e.Fail(&KeyError{Key: key, Err: err})
+		}
+
+		e.Put([]byte(`{"k":`))
+		e.Put(kj)
+		e.Put([]byte(`,"v":`))
+		e.Put(vj)
+		e.Put([]byte("}\n"))
+		return e.Err() == nil
+	})
+
+	return e.Close()
+}
+
+// ImportNDJSON reads `{"k":key,"v":value}` lines from r and sets them in batches, empty lines are skipped.
+// Values are decoded with UnmarshalValueFn if it is set.
+// It returns the number of imported records, the records before a bad line are imported and the error is a *LineError.
+func (mwj *MapWithJSON) ImportNDJSON(r io.Reader) (n int, err error) {
+	if mwj.CMap == nil {
+		mwj.CMap = New()
+	}
+
+	var (
+		br    = bufio.NewReader(r)
+		batch = make([]KV, 0, importBatchSize)
+		line  int
+	)
+
+	flush := func() {
+		mwj.SetBatch(batch)
+		n += len(batch)
+		batch = batch[:0]
+	}
+	defer flush()
+
+	for {
+		ln, rerr := br.ReadBytes('\n')
+		if len(ln) == 0 && rerr != nil {
+			if rerr != io.EOF {
+				err = rerr
+			}
+			return
+		}
+		line++
+
+		if ln = bytes.TrimSpace(ln); len(ln) > 0 {
+			key, v, err := mwj.decodeNDJSON(ln)
+			if err != nil {
+				return n, &LineError{Line: line, Err: err}
+			}
+
+			if batch = append(batch, KV{key, v}); len(batch) == cap(batch) {
+				flush()
+			}
+		}
+
+		if rerr == io.EOF {
+			return
+		} else if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+func (mwj *MapWithJSON) decodeNDJSON(ln []byte) (key string, v interface{}, err error) {
+	var rec ndjsonRecord
+	if err = json.Unmarshal(ln, &rec); err != nil {
+		return
+	}
+	if rec.K == nil {
+		return "", nil, fmt.Errorf(`missing "k"`)
+	}
+	if key = *rec.K; len(rec.V) == 0 {
+		rec.V = json.RawMessage("null")
+	}
+	if v, err = mwj.unmarshalValue(rec.V); err != nil {
+		err = &KeyError{Key: key, Err: err}
+	}
+	return
+}
+
+// ExportNDJSON is a shorthand for cm.WithJSON(nil).ExportNDJSON(w).
+func (cm *CMap) ExportNDJSON(w io.Writer) (n int64, err error) {
+	return cm.WithJSON(nil).ExportNDJSON(w)
+}
+
+// ImportNDJSON is a shorthand for cm.WithJSON(nil).ImportNDJSON(r).
+func (cm *CMap) ImportNDJSON(r io.Reader) (n int, err error) {
+	return cm.WithJSON(nil).ImportNDJSON(r)
+}
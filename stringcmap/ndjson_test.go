@@ -0,0 +1,77 @@
+package stringcmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNDJSON(t *testing.T) {
+	cm := New()
+	for i := 0; i < 3000; i++ {
+		cm.Set("k"+strconv.Itoa(i), map[string]interface{}{"i": float64(i)})
+	}
+
+	var buf bytes.Buffer
+	n, err := cm.ExportNDJSON(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("n = %d, len = %d, err = %v", n, buf.Len(), err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3000 {
+		t.Fatalf("expected 3000 lines, got %d", lines)
+	}
+
+	ncm := New()
+	if n, err := ncm.ImportNDJSON(&buf); err != nil || n != 3000 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	cm.ForEach(func(key string, val interface{}) bool {
+		if nv := ncm.Get(key); !reflect.DeepEqual(val, nv) {
+			t.Fatalf("%s: expected %v, got %v", key, val, nv)
+		}
+		return true
+	})
+}
+
+func TestNDJSONImportErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		line int
+	}{
+		{"{\"k\":\"a\",\"v\":1}\n\n{\"k\":\"b\"}\n{\"k\":\"c\",\"v\":}\n", 2, 4},
+		{"{\"k\":\"a\",\"v\":1}\n{\"v\":2}", 1, 2},
+		{"{\"k\":\"a\",\"v\":1}\r\n{\"k\":\"b\",\"v\":2}", 2, 0},
+	}
+
+	for _, tc := range tests {
+		cm := New()
+		n, err := cm.ImportNDJSON(strings.NewReader(tc.in))
+		if n != tc.n || cm.Len() != tc.n {
+			t.Fatalf("%q: expected %d records, got %d (len %d)", tc.in, tc.n, n, cm.Len())
+		}
+
+		var le *LineError
+		if tc.line == 0 {
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", tc.in, err)
+			}
+		} else if !errors.As(err, &le) || le.Line != tc.line {
+			t.Fatalf("%q: expected an error on line %d, got %v", tc.in, tc.line, err)
+		}
+	}
+
+	mwj := New().WithJSON(func(raw json.RawMessage) (interface{}, error) {
+		n, err := strconv.Atoi(string(raw))
+		return n, err
+	})
+	_, err := mwj.ImportNDJSON(strings.NewReader("{\"k\":\"a\",\"v\":1}\n{\"k\":\"b\",\"v\":\"x\"}\n"))
+	var ke *KeyError
+	if !errors.As(err, &ke) || ke.Key != "b" || mwj.Get("a") != 1 {
+		t.Fatalf("expected a KeyError for b, got %v", err)
+	}
+}
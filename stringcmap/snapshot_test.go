@@ -0,0 +1,55 @@
+package stringcmap
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/OneOfOne/cmap/snapshot"
+)
+
+func TestSnapshot(t *testing.T) {
+	cm := NewSize(32)
+	for i := 0; i < 1000; i++ {
+		cm.Set(strconv.Itoa(i), i)
+	}
+
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := snapshot.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.KeyCodec != snapshot.StringCodecID || sr.ValueCodec != snapshot.GobCodecID || sr.Entries != 1000 {
+		t.Fatalf("unexpected header: %+v", sr.Header)
+	}
+
+	lcm, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lcm.NumShards() != 32 || lcm.Len() != 1000 || lcm.Get("999") != 999 {
+		t.Fatalf("unexpected map: %d shards, %d entries", lcm.NumShards(), lcm.Len())
+	}
+}
+
+func TestSnapshotNil(t *testing.T) {
+	cm := NewSize(4)
+	cm.Set("a", nil)
+	cm.Set("b", 1)
+
+	var buf bytes.Buffer
+	if err := cm.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lcm, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := lcm.GetOK("a"); !ok || v != nil || lcm.Get("b") != 1 {
+		t.Fatalf("unexpected map: %v", lcm.Keys())
+	}
+}
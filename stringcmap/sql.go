@@ -0,0 +1,64 @@
+package stringcmap
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Value implements driver.Valuer, the map is stored as a json object, a nil map is stored as NULL.
+func (mwj MapWithJSON) Value() (driver.Value, error) {
+	if mwj.CMap == nil {
+		return nil, nil
+	}
+	return mwj.MarshalJSON()
+}
+
+// Scan implements sql.Scanner, it replaces the contents of the map with the json object in src, decoding the values
+// with UnmarshalValueFn.
+// The object is decoded into a new map with the same shard count and seed first, then each shard of the existing map
+// swaps in its new contents, so other holders of the *CMap see the new data, but watchers, hooks and the changelog
+// aren't notified. If decoding fails the map is left unchanged.
+// NULL and a json null both set the map to nil, data after the json object is an error.
+func (mwj *MapWithJSON) Scan(src interface{}) error {
+	var p []byte
+	switch src := src.(type) {
+	case nil:
+		mwj.CMap = nil
+		return nil
+	case []byte:
+		p = src
+	case string:
+		p = []byte(src)
+	default:
+		return fmt.Errorf("stringcmap: can't scan %T into a MapWithJSON", src)
+	}
+
+	if bytes.Equal(bytes.TrimSpace(p), []byte("null")) {
+		mwj.CMap = nil
+		return nil
+	}
+
+	cm := New()
+	if mwj.CMap != nil {
+		cm = NewSizeSeed(mwj.NumShards(), mwj.Seed())
+	}
+
+	nmwj := *mwj
+	nmwj.CMap = cm
+	if _, err := nmwj.ReadFrom(bytes.NewReader(p)); err != nil {
+		return err
+	}
+
+	if mwj.CMap == nil {
+		mwj.CMap = cm
+		return nil
+	}
+
+	for i, lm := range mwj.shards {
+		lm.lock()
+		lm.m = cm.shards[i].m
+		lm.l.Unlock()
+	}
+	return nil
+}
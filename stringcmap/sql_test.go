@@ -0,0 +1,123 @@
+package stringcmap
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// fakeDriver stores the last value passed to Exec and returns it from Query.
+type fakeDriver struct{ v driver.Value }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (fakeConn) Close() error                                { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                   { return nil, driver.ErrSkip }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.v = args[0]
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &fakeRows{v: s.d.v}, nil
+}
+
+type fakeRows struct {
+	v    driver.Value
+	done bool
+}
+
+func (*fakeRows) Columns() []string { return []string{"m"} }
+func (*fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.v
+	return nil
+}
+
+var fakeDrv = &fakeDriver{}
+
+func init() { sql.Register("stringcmap-fake", fakeDrv) }
+
+func TestSQL(t *testing.T) {
+	db, err := sql.Open("stringcmap-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	src := MapWithJSON{CMap: New()}
+	for i := 0; i < 100; i++ {
+		src.Set(strconv.Itoa(i), i)
+	}
+
+	if _, err = db.Exec("INSERT", src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fakeDrv.v.([]byte); !ok {
+		t.Fatalf("expected []byte, got %T", fakeDrv.v)
+	}
+
+	dst := New().WithJSON(func(raw json.RawMessage) (interface{}, error) {
+		return strconv.Atoi(string(raw))
+	})
+	dst.Set("stale", 1)
+	cm, seed := dst.CMap, dst.Seed()
+	if err = db.QueryRow("SELECT").Scan(dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Len() != 100 || dst.Has("stale") || dst.Get("42") != 42 {
+		t.Fatalf("unexpected map: len %d, 42 = %v", dst.Len(), dst.Get("42"))
+	}
+	if dst.CMap != cm || cm.Len() != 100 || cm.Seed() != seed {
+		t.Fatal("expected the map to be refilled in place")
+	}
+
+	if _, err = db.Exec("INSERT", `{"a":"b"}`); err != nil {
+		t.Fatal(err)
+	}
+	var smwj MapWithJSON
+	if err = db.QueryRow("SELECT").Scan(&smwj); err != nil || smwj.Get("a") != "b" {
+		t.Fatalf("string scan: %v", err)
+	}
+
+	if _, err = db.Exec("INSERT", MapWithJSON{}); err != nil || fakeDrv.v != nil {
+		t.Fatalf("expected NULL, got %v (%v)", fakeDrv.v, err)
+	}
+	if err = db.QueryRow("SELECT").Scan(&smwj); err != nil || smwj.CMap != nil {
+		t.Fatalf("NULL scan: %v", err)
+	}
+
+	for _, src := range []interface{}{nil, "null", []byte(" null\n")} {
+		smwj := MapWithJSON{CMap: New()}
+		if err = smwj.Scan(src); err != nil || smwj.CMap != nil {
+			t.Fatalf("%q: expected a nil map: %v", src, err)
+		}
+	}
+
+	if err = smwj.Scan(42); err == nil {
+		t.Fatal("expected an error scanning an int")
+	}
+	if err = dst.Scan(`{"a":1} garbage`); err == nil || dst.Len() != 100 || dst.Has("a") {
+		t.Fatalf("trailing data should fail the scan: %v, len %d", err, dst.Len())
+	}
+	if err = dst.Scan(`{"a":"x"}`); err == nil || dst.Len() != 100 {
+		t.Fatalf("a failed scan shouldn't modify the map: %v, len %d", err, dst.Len())
+	}
+}
@@ -0,0 +1,15 @@
+package stringcmap
+
+import "strings"
+
+// MatchPrefix returns a func to be used as WatchFilter.Match that matches keys starting with any of the prefixes.
+func MatchPrefix(prefixes ...string) func(key string) bool {
+	return func(key string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package stringcmap
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWatchPrefix(t *testing.T) {
+	cm := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cm.Watch(ctx, &WatchFilter{Keys: []string{"exact"}, Match: MatchPrefix("cfg/"), Policy: WatchBlock})
+
+	done := make(chan []Event)
+	go func() {
+		var evs []Event
+		for ev := range ch {
+			evs = append(evs, ev)
+		}
+		done <- evs
+	}()
+
+	cm.Set("cfg/a", 1)
+	cm.Set("other", 2)
+	cm.Set("exact", 3)
+	cm.Swap("cfg/a", 4)
+	cm.Delete("cfg/a")
+	cm.Delete("cfg/missing")
+
+	cancel()
+	evs := <-done
+
+	exp := []Event{
+		{Op: EventSet, Key: "cfg/a", NewValue: 1},
+		{Op: EventSet, Key: "exact", NewValue: 3},
+		{Op: EventSwap, Key: "cfg/a", OldValue: 1, NewValue: 4, Existed: true},
+		{Op: EventDelete, Key: "cfg/a", OldValue: 4, Existed: true},
+	}
+	if len(evs) != len(exp) {
+		t.Fatalf("expected %d events, got %+v", len(exp), evs)
+	}
+	for i := range exp {
+		if evs[i] != exp[i] {
+			t.Fatalf("%d: expected %+v, got %+v", i, exp[i], evs[i])
+		}
+	}
+}
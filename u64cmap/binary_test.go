@@ -0,0 +1,31 @@
+package u64cmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestGob(t *testing.T) {
+	cm := NewSize(8)
+	for i := uint64(0); i < 100; i++ {
+		cm.Set(i, "v")
+	}
+	cm.Set(100, nil)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cm); err != nil {
+		t.Fatal(err)
+	}
+
+	var ncm CMap
+	if err := gob.NewDecoder(&buf).Decode(&ncm); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := ncm.GetOK(100); !ok || v != nil {
+		t.Fatal("the nil value wasn't decoded")
+	}
+	if ncm.NumShards() != 8 || ncm.Len() != 101 || ncm.Get(42) != "v" {
+		t.Fatalf("unexpected map: %d shards, %d keys", ncm.NumShards(), ncm.Len())
+	}
+}
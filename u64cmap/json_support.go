@@ -0,0 +1,119 @@
+package u64cmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/OneOfOne/cmap/internal/jsonw"
+)
+
+// WithJSON returns a MapWithJSON with the specific unmarshal func.
+func (cm *CMap) WithJSON(fn func(raw json.RawMessage) (interface{}, error)) *MapWithJSON {
+	return &MapWithJSON{
+		CMap:             cm,
+		UnmarshalValueFn: fn,
+	}
+}
+
+// MapWithJSON is a CMap with json support, keys are encoded as decimal strings.
+// Usage:
+//
+//	var mwj MapWithJSON
+//	json.Unmarshal(`{"1":"value"}`, &mwj)
+type MapWithJSON struct {
+	*CMap
+	UnmarshalValueFn func(raw json.RawMessage) (interface{}, error)
+}
+
+// WriteTo implements io.WriterTo, outputs the map as a json object.
+// It stops at the first marshal or write error and returns it with the number of bytes written to w.
+func (mwj *MapWithJSON) WriteTo(w io.Writer) (n int64, err error) {
+	e := jsonw.NewEncoder(w)
+	e.PutByte('{')
+
+	var kj []byte
+	first := true
+	mwj.ForEach(func(key uint64, val interface{}) bool {
+		if e.Err() != nil {
+			return false
+		}
+
+		vj, err := json.Marshal(val)
+		if err != nil {
+			return e.Fail(err)
+		}
+
+		kj = append(kj[:0], ',', '"')
+		if first {
+			kj = kj[1:]
+			first = false
+		}
+		kj = strconv.AppendUint(kj, key, 10)
+		kj = append(kj, '"', ':')
+
+		e.Put(kj)
+		return e.Put(vj)
+	})
+
+	e.PutByte('}')
+
+	return e.Close()
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (mwj *MapWithJSON) UnmarshalJSON(p []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(p))
+
+	tok, err := dec.Token()
+	if err != nil || tok == nil { // null
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("u64cmap: expected a json object, got %v", tok)
+	}
+
+	if mwj.CMap == nil {
+		mwj.CMap = New()
+	}
+
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+
+		key, err := strconv.ParseUint(tok.(string), 10, 64)
+		if err != nil {
+			return fmt.Errorf("u64cmap: invalid key %q: %v", tok, err)
+		}
+
+		var val interface{}
+		if mwj.UnmarshalValueFn != nil {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err == nil {
+				val, err = mwj.UnmarshalValueFn(raw)
+			}
+		} else {
+			err = dec.Decode(&val)
+		}
+		if err != nil {
+			return err
+		}
+
+		mwj.Set(key, val)
+	}
+
+	_, err = dec.Token() // }
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (cm *CMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := cm.WithJSON(nil).WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
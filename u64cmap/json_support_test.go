@@ -0,0 +1,67 @@
+package u64cmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	cm := New()
+	for i := uint64(0); i < 100; i++ {
+		cm.Set(i<<40, i)
+	}
+
+	j, err := json.Marshal(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mwj := MapWithJSON{
+		UnmarshalValueFn: func(j json.RawMessage) (interface{}, error) {
+			var u uint64
+			err := json.Unmarshal(j, &u)
+			return u, err
+		},
+	}
+	if err = json.Unmarshal(j, &mwj); err != nil {
+		t.Fatal(err)
+	}
+
+	if mwj.Len() != 100 || mwj.Get(99<<40) != uint64(99) {
+		t.Fatalf("unexpected map: %s", j)
+	}
+
+	if err = json.Unmarshal([]byte(`{"x":1}`), &mwj); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
+type failWriter struct{ n int }
+
+func (fw *failWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.n {
+		n := fw.n
+		fw.n = 0
+		return n, io.ErrClosedPipe
+	}
+	fw.n -= len(p)
+	return len(p), nil
+}
+
+func TestJSONWriteToError(t *testing.T) {
+	mwj := New().WithJSON(nil)
+	for i := uint64(0); i < 1000; i++ {
+		mwj.Set(i, i)
+	}
+
+	var buf bytes.Buffer
+	if n, err := mwj.WriteTo(&buf); err != nil || n != int64(buf.Len()) {
+		t.Fatalf("n = %d, len = %d, err = %v", n, buf.Len(), err)
+	}
+
+	if n, err := mwj.WriteTo(&failWriter{n: 100}); err != io.ErrClosedPipe || n != 100 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+}
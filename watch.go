@@ -0,0 +1,225 @@
+// +build genx
+
+package cmap
+
+import (
+	"context"
+	"sync"
+)
+
+// WatchPolicy controls what happens when a watcher can't keep up with the events.
+type WatchPolicy uint8
+
+const (
+	// WatchDrop drops the events that don't fit in the watcher's buffer.
+	WatchDrop WatchPolicy = iota
+	// WatchBlock blocks the mutating caller until the event is received or the watch context is done.
+	WatchBlock
+	// WatchCoalesce keeps one pending event per key, merging the new event into it,
+	// the merged event has the old value of the first event and the new value of the last one.
+	// A delete or evict of a key that didn't exist before the pending event cancels it, nothing is sent.
+	WatchCoalesce
+)
+
+// DefaultWatchBuffer is the channel buffer size used when WatchFilter.Buffer is 0.
+const DefaultWatchBuffer = 64
+
+// WatchFilter selects the events sent to a watcher.
+// If both Keys and Match are empty, all the events are sent.
+type WatchFilter struct {
+	// Keys is a list of exact keys to watch.
+	Keys []KT
+	// Match is called for keys that aren't in Keys, the event is sent if it returns true.
+	Match func(key KT) bool
+
+	Policy WatchPolicy
+	Buffer int
+}
+
+// Watch returns a channel that receives an Event after every mutation of a key matching filter.
+// Events are sent after the shard is unlocked, so events of concurrent mutations of the same key may be
+// received out of order.
+// Only mutations done through the CMap are reported, not the ones done directly on a shard's LMap.
+// The channel is closed after ctx is done.
+func (cm *CMap) Watch(ctx context.Context, filter *WatchFilter) <-chan Event {
+	var f WatchFilter
+	if filter != nil {
+		f = *filter
+	}
+	if f.Buffer < 1 {
+		f.Buffer = DefaultWatchBuffer
+	}
+
+	w := &watcher{
+		ctx:    ctx,
+		ch:     make(chan Event, f.Buffer),
+		match:  f.Match,
+		policy: f.Policy,
+	}
+
+	if len(f.Keys) > 0 {
+		w.keys = make(map[KT]struct{}, len(f.Keys))
+		for _, k := range f.Keys {
+			w.keys[k] = struct{}{}
+		}
+	}
+
+	if w.policy == WatchCoalesce {
+		w.pending = make(map[KT]*Event)
+		w.wake = make(chan struct{}, 1)
+		go w.deliver()
+	}
+
+	cm.updateObservers(func(ob *observers) {
+		ob.watchers = append(ob.watchers[:len(ob.watchers):len(ob.watchers)], w)
+	})
+
+	go func() {
+		<-ctx.Done()
+		cm.updateObservers(func(ob *observers) {
+			ws := make([]*watcher, 0, len(ob.watchers))
+			for _, ow := range ob.watchers {
+				if ow != w {
+					ws = append(ws, ow)
+				}
+			}
+			ob.watchers = ws
+		})
+		w.close()
+	}()
+
+	return w.ch
+}
+
+type watcher struct {
+	ctx    context.Context
+	ch     chan Event
+	keys   map[KT]struct{}
+	match  func(key KT) bool
+	policy WatchPolicy
+
+	mux    sync.RWMutex
+	closed bool
+
+	// used by WatchCoalesce
+	pending map[KT]*Event
+	order   []KT
+	wake    chan struct{}
+}
+
+func (w *watcher) matches(key KT) bool {
+	if w.keys == nil && w.match == nil {
+		return true
+	}
+	if _, ok := w.keys[key]; ok {
+		return true
+	}
+	return w.match != nil && w.match(key)
+}
+
+func (w *watcher) notify(ev *Event) {
+	if !w.matches(ev.Key) {
+		return
+	}
+
+	if w.policy == WatchCoalesce {
+		w.coalesce(ev)
+		return
+	}
+
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.closed {
+		return
+	}
+
+	if w.policy == WatchBlock {
+		select {
+		case w.ch <- *ev:
+		case <-w.ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case w.ch <- *ev:
+	default:
+	}
+}
+
+func (w *watcher) coalesce(ev *Event) {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return
+	}
+
+	if p, ok := w.pending[ev.Key]; ok {
+		if !p.Existed && (ev.Op == EventDelete || ev.Op == EventEvict) {
+			w.drop(ev.Key)
+		} else {
+			p.Op, p.NewValue = ev.Op, ev.NewValue
+		}
+	} else {
+		e := *ev
+		w.pending[ev.Key] = &e
+		w.order = append(w.order, ev.Key)
+	}
+	w.mux.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop removes the pending event of key, w.mux must be held.
+func (w *watcher) drop(key KT) {
+	delete(w.pending, key)
+	for i, k := range w.order {
+		if k == key {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// deliver sends the coalesced events in the order their keys were first modified.
+func (w *watcher) deliver() {
+	defer close(w.ch)
+	for {
+		select {
+		case <-w.wake:
+		case <-w.ctx.Done():
+			return
+		}
+
+		for {
+			w.mux.Lock()
+			if len(w.order) == 0 {
+				w.mux.Unlock()
+				break
+			}
+			key := w.order[0]
+			w.order = w.order[1:]
+			ev := w.pending[key]
+			delete(w.pending, key)
+			w.mux.Unlock()
+
+			select {
+			case w.ch <- *ev:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (w *watcher) close() {
+	w.mux.Lock()
+	w.closed = true
+	if w.policy != WatchCoalesce { // deliver owns the channel
+		close(w.ch)
+	}
+	w.mux.Unlock()
+}
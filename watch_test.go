@@ -0,0 +1,111 @@
+package cmap_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OneOfOne/cmap"
+)
+
+func TestWatch(t *testing.T) {
+	cm := cmap.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all := cm.Watch(ctx, &cmap.WatchFilter{Policy: cmap.WatchBlock})
+	drop := cm.Watch(ctx, &cmap.WatchFilter{Keys: []interface{}{1}, Buffer: 1})
+
+	if !cm.SetIfNotExists(1, "a") || cm.SetIfNotExists(1, "b") {
+		t.Fatal("unexpected SetIfNotExists result")
+	}
+	if ev := <-all; ev.Op != cmap.EventSet || ev.Key != 1 || ev.NewValue != "a" || ev.Existed {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	cm.Update(1, func(old interface{}) interface{} { return old.(string) + "b" })
+	if ev := <-all; ev.Op != cmap.EventUpdate || ev.OldValue != "a" || ev.NewValue != "ab" || !ev.Existed {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if v := cm.DeleteAndGet(1); v != "ab" {
+		t.Fatalf("expected ab, got %v", v)
+	}
+	if ev := <-all; ev.Op != cmap.EventDelete || ev.OldValue != "ab" {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// the buffer of drop is full since the first event, the rest were dropped
+	if ev := <-drop; ev.Op != cmap.EventSet {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	select {
+	case ev := <-drop:
+		t.Fatalf("expected the event to be dropped, got %+v", ev)
+	default:
+	}
+}
+
+func TestWatchCoalesce(t *testing.T) {
+	cm := cmap.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cm.Watch(ctx, &cmap.WatchFilter{Policy: cmap.WatchCoalesce, Buffer: 1})
+
+	for i := 0; i < 100; i++ {
+		cm.Set("a", i)
+		cm.Set("b", i)
+	}
+
+	last := map[interface{}]interface{}{}
+	timeout := time.After(5 * time.Second)
+	for last["a"] != 99 || last["b"] != 99 {
+		select {
+		case ev := <-ch:
+			last[ev.Key] = ev.NewValue
+		case <-timeout:
+			t.Fatalf("timed out, last values: %v", last)
+		}
+	}
+
+	cancel()
+	for range ch {
+	}
+
+	cm.Set("a", 1) // no watchers left, must not block or panic
+}
+
+func TestWatchCoalesceCancel(t *testing.T) {
+	cm := cmap.New()
+	cm.Set("e", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := cm.Watch(ctx, &cmap.WatchFilter{Policy: cmap.WatchCoalesce, Buffer: 1})
+
+	// x fills the buffer and y blocks the delivery, so the next events are coalesced.
+	cm.Set("x", 1)
+	cm.Set("y", 1)
+
+	cm.Set("n", 1)
+	cm.Delete("n") // n never existed, nothing to report
+	cm.Set("e", 1)
+	cm.Delete("e")
+	cm.Set("z", 1)
+
+	var evs []cmap.Event
+	timeout := time.After(5 * time.Second)
+	for len(evs) == 0 || evs[len(evs)-1].Key != "z" {
+		select {
+		case ev := <-ch:
+			evs = append(evs, ev)
+		case <-timeout:
+			t.Fatalf("timed out: %+v", evs)
+		}
+	}
+
+	if len(evs) != 4 || evs[0].Key != "x" || evs[1].Key != "y" || evs[2].Key != "e" {
+		t.Fatalf("unexpected events: %+v", evs)
+	}
+	if e := evs[2]; e.Op != cmap.EventDelete || !e.Existed || e.OldValue != 0 {
+		t.Fatalf("unexpected merged event: %+v", e)
+	}
+}